@@ -1,70 +1,298 @@
+// Package almond implements a tokenizer, parser and interpreter for the
+// almond scripting language.
+//
+// Concurrency: all run state lives on an Interpreter or Engine, so separate
+// instances may run in parallel goroutines. A single Interpreter, Engine or
+// Function handle must only be used by one goroutine at a time. Parsed
+// statements are never modified while running and may be shared between
+// instances. Host values wrapped with WrapStruct or FromGo are shared with
+// the host, which is responsible for synchronizing access to them.
 package almond
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
-// Process input string
-func run(inter *Interpreter, line string) {
-	tokenizer := NewTokenizer(line)
-	allToks := tokenizer.Tokenize()
-	parser := NewParser(allToks)
+// Process input, reporting syntax and runtime errors
+func run(inter *Interpreter, input io.Reader) (hadFault bool, hadRuntimeFault bool) {
+	tokenizer := NewStreamTokenizer(input)
+	parser := NewStreamParser(tokenizer)
 	statements := parser.Parse()
 
-	if HadFault {
-		return
+	if len(tokenizer.Faults()) > 0 || len(parser.Faults()) > 0 {
+		return true, false
 	}
 
-	inter.Interpret(statements)
+	err := inter.Interpret(statements)
+
+	if err != nil {
+		printRuntimeError(os.Stdout, err)
+		return false, true
+	}
+	return false, false
+}
+
+// Command line settings shared by RunFile and RunPrompt
+type RunOptions struct {
+	// Run on the tree-walking interpreter instead of the bytecode vm
+	TreeWalk bool
+	// Skip constant folding and dead code removal
+	NoOptimize bool
+	// Write a pprof profile of the script to this file, runs on the tree-walker
+	CPUProfile string
+	// Flush print output after every line instead of when the buffer fills
+	LineBuffered bool
+	// Natives scripts may use, nil uses DefaultCapabilities
+	Capabilities *Capabilities
+}
+
+// Interpreter for the command line
+func newCommandInterpreter(opts RunOptions) (*Interpreter, error) {
+	caps := DefaultCapabilities
+	if opts.Capabilities != nil {
+		caps = *opts.Capabilities
+	}
+
+	inter, err := NewSandboxInterpreter(caps)
+
+	if err != nil {
+		return nil, err
+	}
+
+	inter.SetTreeWalk(opts.TreeWalk)
+	inter.SetOptimize(!opts.NoOptimize)
+	inter.SetLineBuffered(opts.LineBuffered)
+	return inter, nil
 }
 
-// Run the code from a file
-func RunFile(filename string) error {
+// Run the code from a file, .alc files are run as bytecode
+func RunFile(filename string, opts RunOptions) error {
+	if filepath.Ext(filename) == ".alc" {
+		return runBytecodeFile(filename, opts)
+	}
+
 	// Create a new interpreter
-	inter := NewInterpreter()
+	inter, e := newCommandInterpreter(opts)
+
+	if e != nil {
+		return e
+	}
 
-	// read file bytes and get error
-	data, e := os.ReadFile(filename)
+	// open the file, it is tokenized as it is read
+	file, e := os.Open(filename)
 
 	// propogate error
 	if e != nil {
 		return e
 	}
+	defer file.Close()
 
-	// convert byte to string and run code
-	run(inter, string(data))
+	var profiler *Profiler
+	if opts.CPUProfile != "" {
+		profiler = NewProfiler(filename)
+		inter.SetHooks(profiler)
+	}
+
+	// run code
+	hadFault, hadRuntimeFault := run(inter, file)
 
 	// exit if there is an error in the code
-	if HadFault {
+	if hadFault {
 		os.Exit(65)
 	}
-	if HadRuntimeFault {
+
+	// a script that failed at runtime still has a profile up to the error
+	if profiler != nil {
+		if err := profiler.WriteFile(opts.CPUProfile); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not write profile:", err)
+		}
+	}
+	if hadRuntimeFault {
 		os.Exit(70)
 	}
 	return e
 }
 
+// Compile a source file to bytecode written to output
+func CompileFile(filename, output string, opts RunOptions) error {
+	if filepath.Ext(filename) == ".alc" {
+		return fmt.Errorf("%s is already bytecode, compile its source instead", filename)
+	}
+
+	if sameFile(filename, output) {
+		return fmt.Errorf("output %s would overwrite the source", output)
+	}
+
+	source, err := os.ReadFile(filename)
+
+	if err != nil {
+		return err
+	}
+
+	chunk, err := compileSource(source, opts)
+
+	if err != nil {
+		return err
+	}
+	return writeBytecode(output, chunk, source, filename, !opts.NoOptimize)
+}
+
+// Paths naming the same file, also through links
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+
+	if errA == nil && errB == nil && absA == absB {
+		return true
+	}
+
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// Parse and compile source, syntax errors are printed as when running it
+func compileSource(source []byte, opts RunOptions) (*Chunk, error) {
+	tokenizer := NewStreamTokenizer(bytes.NewReader(source))
+	parser := NewStreamParser(tokenizer)
+	statements := parser.Parse()
+
+	faults := append(tokenizer.Faults(), parser.Faults()...)
+
+	if len(faults) > 0 {
+		return nil, errors.Join(faults...)
+	}
+
+	if !opts.NoOptimize {
+		statements = optimize(statements)
+	}
+	return compile(statements)
+}
+
+// Write bytecode, the source path is stored relative to the output
+func writeBytecode(output string, chunk *Chunk, source []byte, sourcePath string, optimized bool) error {
+	absOutput, err := filepath.Abs(output)
+
+	if err != nil {
+		return err
+	}
+
+	absSource, err := filepath.Abs(sourcePath)
+
+	if err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(absOutput), absSource)
+
+	if err != nil {
+		rel = absSource
+	}
+	return os.WriteFile(output, encodeBytecode(chunk, source, rel, optimized), 0o644)
+}
+
+// Run a .alc file. A stale or incompatible file, or one compiled with other
+// optimize settings, is recompiled from its source and rewritten. Bytecode
+// whose source is gone runs as it is.
+func runBytecodeFile(filename string, opts RunOptions) error {
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		return err
+	}
+
+	file, err := decodeBytecode(data)
+
+	if errors.Is(err, ErrNotBytecode) {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	sourcePath := file.sourcePath
+	if !filepath.IsAbs(sourcePath) {
+		sourcePath = filepath.Join(filepath.Dir(filename), sourcePath)
+	}
+
+	source, readErr := os.ReadFile(sourcePath)
+	stale := readErr == nil && (sha256.Sum256(source) != file.sourceHash || file.optimized == opts.NoOptimize)
+
+	if err != nil && readErr != nil {
+		return fmt.Errorf("%s: %w, recompile it from source", filename, err)
+	}
+
+	// bytecode only runs on the vm, profiles are taken on the tree-walker
+	if opts.TreeWalk || opts.CPUProfile != "" {
+		if readErr != nil {
+			return fmt.Errorf("%s: bytecode cannot run on the tree-walker and its source is missing", filename)
+		}
+		return RunFile(sourcePath, opts)
+	}
+
+	chunk := file.chunk
+
+	if err != nil || stale {
+		fmt.Fprintf(os.Stderr, "%s is out of date, recompiling from %s\n", filename, sourcePath)
+		chunk, err = compileSource(source, opts)
+
+		var fault *ParseFault
+		if errors.As(err, &fault) {
+			os.Exit(65)
+		}
+
+		// code the vm cannot hold runs from source
+		if err != nil {
+			return RunFile(sourcePath, opts)
+		}
+
+		err = writeBytecode(filename, chunk, source, sourcePath, !opts.NoOptimize)
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not update %s: %v\n", filename, err)
+		}
+	}
+
+	inter, err := newCommandInterpreter(opts)
+
+	if err != nil {
+		return err
+	}
+
+	err = inter.runCompiled(chunk)
+
+	if err != nil {
+		printRuntimeError(os.Stdout, err)
+		os.Exit(70)
+	}
+	return nil
+}
+
 // Run interactive console
-func RunPrompt() error {
+func RunPrompt(opts RunOptions) error {
 	// Create a new interpreter
-	inter := NewInterpreter()
+	inter, err := newCommandInterpreter(opts)
+
+	if err != nil {
+		return err
+	}
 
 	// Scan console inputs
 	scanner := bufio.NewScanner(os.Stdin)
 
 	fmt.Print("To exit press Ctrl+C...\n> ")
 
-	// Readline and process text
+	// Readline and process text, errors dont kill the session
 	for scanner.Scan() {
 		text := scanner.Text()
 
 		// Run and store line input
-		run(inter, text)
-
-		// Dont kill session if there is an error
-		HadFault = false
+		run(inter, strings.NewReader(text))
 
 		fmt.Print("> ")
 	}
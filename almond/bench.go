@@ -0,0 +1,117 @@
+package almond
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// Timings from running a script repeatedly
+type BenchResult struct {
+	// Wall time of each run in order
+	Times []time.Duration
+	// Heap allocations and allocated bytes, averaged over the runs
+	Allocs uint64
+	Bytes  uint64
+}
+
+func (r BenchResult) Mean() time.Duration {
+	var total time.Duration
+
+	for _, elapsed := range r.Times {
+		total += elapsed
+	}
+	return total / time.Duration(len(r.Times))
+}
+
+func (r BenchResult) Median() time.Duration {
+	sorted := r.sorted()
+	mid := len(sorted) / 2
+
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func (r BenchResult) Min() time.Duration {
+	return r.sorted()[0]
+}
+
+func (r BenchResult) Max() time.Duration {
+	return r.sorted()[len(r.Times)-1]
+}
+
+// copy of the times, fastest first
+func (r BenchResult) sorted() []time.Duration {
+	sorted := append([]time.Duration{}, r.Times...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+	return sorted
+}
+
+// Run a source file n times, see Bench
+func BenchFile(filename string, n int, opts RunOptions) (BenchResult, error) {
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		return BenchResult{}, err
+	}
+
+	// bytecode would be parsed as source
+	if bytes.HasPrefix(data, bytecodeMagic[:]) {
+		return BenchResult{}, fmt.Errorf("%s is bytecode, bench its source instead", filename)
+	}
+
+	return Bench(string(data), n, opts)
+}
+
+// Run source n times, each on a fresh command line interpreter with
+// print output discarded. Parsing and loading the prelude are not timed.
+func Bench(source string, n int, opts RunOptions) (BenchResult, error) {
+	if n < 1 {
+		return BenchResult{}, errors.New("bench needs at least one run")
+	}
+
+	statements, err := parseSource(source)
+
+	if err != nil {
+		return BenchResult{}, err
+	}
+
+	result := BenchResult{Times: make([]time.Duration, 0, n)}
+	var before, after runtime.MemStats
+
+	for idx := 0; idx < n; idx++ {
+		inter, err := newCommandInterpreter(opts)
+
+		if err != nil {
+			return BenchResult{}, err
+		}
+		inter.SetOutput(io.Discard)
+
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+
+		err = inter.Interpret(statements)
+
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		if err != nil {
+			return BenchResult{}, err
+		}
+
+		result.Times = append(result.Times, elapsed)
+		result.Allocs += after.Mallocs - before.Mallocs
+		result.Bytes += after.TotalAlloc - before.TotalAlloc
+	}
+
+	result.Allocs /= uint64(n)
+	result.Bytes /= uint64(n)
+	return result, nil
+}
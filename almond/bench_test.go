@@ -0,0 +1,152 @@
+package almond
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Programs for the run benchmarks, each leans on a different part of the interpreter
+var benchScripts = []struct {
+	name string
+	src  string
+}{
+	// calls and parameter reads
+	{"fib", `
+		fn fib(n) { if (n <= 1) return n; return fib(n - 2) + fib(n - 1); }
+		fib(18);`},
+
+	// locals updated in a tight loop inside a function
+	{"loop", `
+		fn sum() {
+			var total = 0;
+			for (var i = 0; i < 10000; i = i + 1) {
+				var half = i / 2;
+				total = total + half;
+			}
+			return total;
+		}
+		sum();`},
+
+	// concatenation and memory accounting
+	{"strings", `
+		var s = "";
+		for (var i = 0; i < 2000; i = i + 1) {
+			s = s + "ab";
+		}
+		s;`},
+
+	// closures created and called in a loop
+	{"closures", `
+		fn counter() { var c = 0; fn inc() { c = c + 1; return c; } return inc; }
+		var total = 0;
+		for (var i = 0; i < 2000; i = i + 1) {
+			var next = counter();
+			next();
+			total = total + next();
+		}
+		total;`},
+}
+
+// Every benchmark script pasted together n times
+func benchSource(n int) string {
+	var src strings.Builder
+
+	for idx := 0; idx < n; idx++ {
+		for _, script := range benchScripts {
+			src.WriteString(script.src + "\n")
+		}
+	}
+	return src.String()
+}
+
+func BenchmarkTokenize(b *testing.B) {
+	src := benchSource(100)
+	b.SetBytes(int64(len(src)))
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		NewTokenizer(src).Tokenize()
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	tokens := NewTokenizer(benchSource(100)).Tokenize()
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		NewParser(tokens).Parse()
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	for _, script := range benchScripts {
+		b.Run(script.name+"/vm", func(b *testing.B) { benchmarkScript(b, script.src, false) })
+		b.Run(script.name+"/tree", func(b *testing.B) { benchmarkScript(b, script.src, true) })
+	}
+}
+
+func benchmarkScript(b *testing.B, src string, treeWalk bool) {
+	statements, err := parseSource(src)
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	inter, _ := NewInterpreter()
+	inter.SetOutput(io.Discard)
+	inter.SetTreeWalk(treeWalk)
+	b.ReportAllocs()
+
+	for n := 0; n < b.N; n++ {
+		if err := inter.Interpret(statements); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestBenchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "loop.al")
+	os.WriteFile(path, []byte(`var s = ""; for (var i = 0; i < 50; i = i + 1) s = s + "x"; print s;`), 0o644)
+
+	result, err := BenchFile(path, 5, RunOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Times) != 5 || result.Allocs == 0 || result.Bytes == 0 {
+		t.Errorf("got %+v", result)
+	}
+
+	if result.Min() > result.Median() || result.Median() > result.Max() {
+		t.Errorf("median %v outside %v..%v", result.Median(), result.Min(), result.Max())
+	}
+
+	_, err = Bench(`print missing;`, 3, RunOptions{})
+
+	if err == nil || err.Error() != "Undefined variable 'missing'. at line 1" {
+		t.Errorf("got error %v", err)
+	}
+
+	compiled := filepath.Join(t.TempDir(), "loop.alc")
+
+	if err := CompileFile(path, compiled, RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := BenchFile(compiled, 1, RunOptions{}); err == nil || !strings.Contains(err.Error(), "is bytecode") {
+		t.Errorf("bytecode: got %v", err)
+	}
+}
+
+func TestBenchResultStats(t *testing.T) {
+	result := BenchResult{Times: []time.Duration{4, 1, 3, 2}}
+
+	if result.Mean() != 2 || result.Median() != 2 || result.Min() != 1 || result.Max() != 4 {
+		t.Errorf("got mean %v median %v min %v max %v", result.Mean(), result.Median(), result.Min(), result.Max())
+	}
+}
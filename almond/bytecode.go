@@ -0,0 +1,709 @@
+package almond
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+)
+
+// Version of the .alc format. Bump it whenever opcodes, token types or the
+// encoding of a chunk change, older files are then recompiled from source.
+const BytecodeVersion = 2
+
+// First bytes of every .alc file
+var bytecodeMagic = [4]byte{'A', 'L', 'C', 0}
+
+var (
+	// Not a bytecode file at all
+	ErrNotBytecode = errors.New("not an almond bytecode file")
+	// Written by another version of almond or damaged
+	ErrIncompatibleBytecode = errors.New("incompatible bytecode file")
+)
+
+// Header and code of a .alc file. The header layout is the same in every
+// version so the source of an incompatible file can still be found.
+type bytecodeFile struct {
+	version int
+	// sha256 of the source the code was compiled from
+	sourceHash [sha256.Size]byte
+	// source path, relative to the directory of the .alc file
+	sourcePath string
+	// whether constants were folded before compiling
+	optimized bool
+	chunk     *Chunk
+}
+
+// Encode a compiled script with the source it came from
+func encodeBytecode(chunk *Chunk, source []byte, sourcePath string, optimized bool) []byte {
+	body := &encoder{}
+	body.boolean(optimized)
+	body.chunk(chunk)
+
+	out := &encoder{}
+	out.buf.Write(bytecodeMagic[:])
+	out.u32(BytecodeVersion)
+	hash := sha256.Sum256(source)
+	out.buf.Write(hash[:])
+	out.str(sourcePath)
+	out.u32(crc32.ChecksumIEEE(body.buf.Bytes()))
+	out.buf.Write(body.buf.Bytes())
+
+	return out.buf.Bytes()
+}
+
+// Decode a .alc file. A file from another version or with a bad checksum
+// returns its header along with ErrIncompatibleBytecode.
+func decodeBytecode(data []byte) (*bytecodeFile, error) {
+	if !bytes.HasPrefix(data, bytecodeMagic[:]) {
+		return nil, ErrNotBytecode
+	}
+
+	d := &decoder{data: data[len(bytecodeMagic):]}
+	file := &bytecodeFile{version: int(d.u32())}
+	copy(file.sourceHash[:], d.bytes(sha256.Size))
+	file.sourcePath = d.str()
+	sum := d.u32()
+
+	if d.err != nil {
+		return nil, ErrNotBytecode
+	}
+
+	if file.version != BytecodeVersion {
+		return file, fmt.Errorf("%w: version %d, expected %d", ErrIncompatibleBytecode, file.version, BytecodeVersion)
+	}
+
+	if crc32.ChecksumIEEE(d.data) != sum {
+		return file, fmt.Errorf("%w: checksum mismatch", ErrIncompatibleBytecode)
+	}
+
+	file.optimized = d.boolean()
+	file.chunk = d.chunk()
+
+	if d.err == nil {
+		// the checksum only catches damage, crafted code must not crash the vm
+		d.err = file.chunk.verify(nil, 0)
+	}
+
+	if d.err != nil {
+		return file, fmt.Errorf("%w: %v", ErrIncompatibleBytecode, d.err)
+	}
+	return file, nil
+}
+
+// ----- Encoding, integers are big endian and lists are prefixed by their length
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) u8(n byte) {
+	e.buf.WriteByte(n)
+}
+
+func (e *encoder) u32(n uint32) {
+	e.buf.Write(binary.BigEndian.AppendUint32(nil, n))
+}
+
+// signed values such as global slots (-1)
+func (e *encoder) int(n int) {
+	e.u32(uint32(int32(n)))
+}
+
+func (e *encoder) str(s string) {
+	e.int(len(s))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) boolean(b bool) {
+	if b {
+		e.u8(1)
+	} else {
+		e.u8(0)
+	}
+}
+
+func (e *encoder) value(value Object) {
+	e.u8(byte(value.kind))
+
+	switch value.kind {
+	case kindNumber:
+		e.buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(value.num)))
+	case kindString:
+		e.str(value.ref.(string))
+	}
+}
+
+func (e *encoder) token(tok Token) {
+	e.int(int(tok.kind))
+	e.str(tok.lexeme)
+	e.int(tok.line)
+
+	switch literal := tok.literal.(type) {
+	case float64:
+		e.value(numberValue(literal))
+	case string:
+		e.value(stringValue(literal))
+	default:
+		e.value(nullValue())
+	}
+}
+
+func (e *encoder) frame(frame *frameInfo) {
+	e.boolean(frame != nil)
+
+	if frame != nil {
+		e.int(len(frame.names))
+		for _, name := range frame.names {
+			e.str(name)
+		}
+	}
+}
+
+func (e *encoder) chunk(c *Chunk) {
+	e.str(string(c.code))
+
+	e.int(len(c.lines))
+	for _, line := range c.lines {
+		e.int(line.offset)
+		e.int(line.line)
+	}
+
+	e.int(len(c.constants))
+	for _, constant := range c.constants {
+		e.value(constant)
+	}
+
+	e.int(len(c.tokens))
+	for _, tok := range c.tokens {
+		e.token(tok)
+	}
+
+	e.int(len(c.locals))
+	for _, local := range c.locals {
+		e.int(local.depth)
+		e.int(local.slot)
+		e.token(local.name)
+	}
+
+	e.int(len(c.frames))
+	for _, frame := range c.frames {
+		e.frame(frame)
+	}
+
+	// function bodies are only kept as code
+	e.int(len(c.functions))
+	for _, fn := range c.functions {
+		e.token(fn.declaration.name)
+		e.int(len(fn.declaration.params))
+		for _, param := range fn.declaration.params {
+			e.token(param)
+		}
+		e.int(fn.declaration.slot)
+		e.frame(fn.declaration.frame)
+		e.chunk(fn.chunk)
+	}
+
+	e.int(len(c.asserts))
+	for _, info := range c.asserts {
+		e.token(info.keyword)
+		e.str(info.source)
+		e.boolean(info.compare)
+		e.boolean(info.message)
+	}
+}
+
+// ----- Decoding, the first error sticks and later reads return zero values
+
+// Deepest nesting of function chunks a decoder accepts
+const maxChunkDepth = 1000
+
+type decoder struct {
+	data  []byte
+	err   error
+	depth int
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err == nil && (n < 0 || n > len(d.data)) {
+		d.err = errors.New("unexpected end of data")
+	}
+
+	if d.err != nil {
+		return nil
+	}
+
+	out := d.data[:n]
+	d.data = d.data[n:]
+	return out
+}
+
+func (d *decoder) u8() byte {
+	b := d.bytes(1)
+
+	if d.err != nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) u32() uint32 {
+	b := d.bytes(4)
+
+	if d.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) int() int {
+	return int(int32(d.u32()))
+}
+
+// length of a list or string, every element takes at least a byte so
+// lengths past the end of the data are refused before allocating
+func (d *decoder) length() int {
+	n := d.int()
+
+	if d.err == nil && (n < 0 || n > len(d.data)) {
+		d.err = fmt.Errorf("invalid length %d", n)
+	}
+
+	if d.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) str() string {
+	return string(d.bytes(d.length()))
+}
+
+func (d *decoder) boolean() bool {
+	return d.u8() != 0
+}
+
+func (d *decoder) value() Object {
+	switch kind := valueKind(d.u8()); kind {
+	case kindNull:
+		return nullValue()
+	case kindTrue:
+		return boolValue(true)
+	case kindFalse:
+		return boolValue(false)
+	case kindNumber:
+		b := d.bytes(8)
+
+		if d.err != nil {
+			return Object{}
+		}
+		return numberValue(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case kindString:
+		return stringValue(d.str())
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("invalid constant kind %d", kind)
+		}
+		return Object{}
+	}
+}
+
+func (d *decoder) token() Token {
+	tok := Token{kind: TokenType(d.int()), lexeme: d.str(), line: d.int()}
+	literal := d.value()
+
+	switch literal.kind {
+	case kindNumber:
+		tok.literal = literal.num
+	case kindString:
+		tok.literal = literal.ref
+	}
+	return tok
+}
+
+func (d *decoder) frame() *frameInfo {
+	if !d.boolean() {
+		return nil
+	}
+
+	frame := &frameInfo{make([]string, d.length())}
+	for idx := range frame.names {
+		frame.names[idx] = d.str()
+	}
+	return frame
+}
+
+func (d *decoder) chunk() *Chunk {
+	d.depth++
+	defer func() { d.depth-- }()
+
+	if d.err == nil && d.depth > maxChunkDepth {
+		d.err = errors.New("functions nested too deeply")
+	}
+
+	c := &Chunk{code: []byte(d.str())}
+
+	c.lines = make([]lineStart, d.length())
+	for idx := range c.lines {
+		c.lines[idx] = lineStart{d.int(), d.int()}
+	}
+
+	c.constants = make([]Object, d.length())
+	for idx := range c.constants {
+		c.constants[idx] = d.value()
+	}
+
+	c.tokens = make([]Token, d.length())
+	for idx := range c.tokens {
+		c.tokens[idx] = d.token()
+	}
+
+	c.locals = make([]localRef, d.length())
+	for idx := range c.locals {
+		c.locals[idx] = localRef{d.int(), d.int(), d.token()}
+	}
+
+	c.frames = make([]*frameInfo, d.length())
+	for idx := range c.frames {
+		c.frames[idx] = d.frame()
+	}
+
+	c.functions = make([]fnProto, d.length())
+	for idx := range c.functions {
+		declaration := FnStmt{name: d.token()}
+
+		declaration.params = make([]Token, d.length())
+		for param := range declaration.params {
+			declaration.params[param] = d.token()
+		}
+
+		declaration.slot = d.int()
+		declaration.frame = d.frame()
+		c.functions[idx] = fnProto{declaration, d.chunk()}
+	}
+
+	c.asserts = make([]assertInfo, d.length())
+	for idx := range c.asserts {
+		c.asserts[idx] = assertInfo{d.token(), d.str(), d.boolean(), d.boolean()}
+	}
+	return c
+}
+
+// ----- Verifying, decoded code is checked before it runs
+
+// Values on the stack and scopes entered at an instruction
+type verifyState struct {
+	height int
+	scopes []*frameInfo
+}
+
+func (v verifyState) same(other verifyState) bool {
+	if v.height != other.height || len(v.scopes) != len(other.scopes) {
+		return false
+	}
+
+	for idx := range v.scopes {
+		if v.scopes[idx] != other.scopes[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// Check that every operand is in range, jumps land on instructions and every
+// path agrees on the stack height and scopes. scopes are the frames the chunk
+// starts in, innermost last, nil for scopes without slots.
+func (c *Chunk) verify(scopes []*frameInfo, depth int) error {
+	if depth > maxChunkDepth {
+		return errors.New("functions nested too deeply")
+	}
+
+	// instruction boundaries
+	starts := make([]bool, len(c.code)+1)
+	for pc := 0; pc < len(c.code); {
+		starts[pc] = true
+		op := OpCode(c.code[pc])
+
+		if _, ok := opNames[op]; !ok {
+			return fmt.Errorf("unknown opcode %d at %d", op, pc)
+		}
+		pc += 1 + op.operandSize()
+
+		if pc > len(c.code) {
+			return fmt.Errorf("truncated %s instruction", op)
+		}
+	}
+	starts[len(c.code)] = true
+
+	states := make([]*verifyState, len(c.code)+1)
+	work := []int{0}
+	states[0] = &verifyState{0, scopes}
+	usedFunctions := make([]bool, len(c.functions))
+
+	// record the state an instruction is reached in
+	reach := func(from, target int, state verifyState) error {
+		if target < 0 || target > len(c.code) || !starts[target] {
+			return fmt.Errorf("jump at %d to %d is not an instruction", from, target)
+		}
+
+		if states[target] == nil {
+			states[target] = &state
+			work = append(work, target)
+		} else if !states[target].same(state) {
+			return fmt.Errorf("paths disagree on the stack or scopes at %d", target)
+		}
+		return nil
+	}
+
+	index := func(pc, n int, what string) (int, error) {
+		idx := c.u16(pc + 1)
+
+		if idx >= n {
+			return 0, fmt.Errorf("%s %d out of range at %d", what, idx, pc)
+		}
+		return idx, nil
+	}
+
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		if pc == len(c.code) {
+			continue
+		}
+
+		op := OpCode(c.code[pc])
+		state := *states[pc]
+		next := pc + 1 + op.operandSize()
+
+		// values popped and pushed
+		pops, pushes := 0, 0
+		var err error
+
+		switch op {
+		case OP_CONSTANT:
+			_, err = index(pc, len(c.constants), "constant")
+			pushes = 1
+
+		case OP_POP, OP_RESULT, OP_PRINT:
+			pops = 1
+
+		case OP_GET, OP_SET, OP_DEFINE, OP_PROPERTY, OP_UNARY, OP_BINARY, OP_COMPARE, OP_CHECKPOINT:
+			_, err = index(pc, len(c.tokens), "token")
+
+			switch op {
+			case OP_GET:
+				pushes = 1
+			case OP_SET, OP_PROPERTY, OP_UNARY:
+				pops, pushes = 1, 1
+			case OP_DEFINE:
+				pops = 1
+			case OP_BINARY:
+				pops, pushes = 2, 1
+			case OP_COMPARE:
+				pops, pushes = 2, 3
+			}
+
+		case OP_GET_LOCAL, OP_SET_LOCAL:
+			var idx int
+			idx, err = index(pc, len(c.locals), "local")
+
+			if err == nil {
+				err = checkLocal(c.locals[idx], state.scopes, pc)
+			}
+
+			pushes = 1
+			if op == OP_SET_LOCAL {
+				pops = 1
+			}
+
+		case OP_DEFINE_LOCAL:
+			err = checkSlot(c.u16(pc+1), state.scopes, pc)
+			pops = 1
+
+		case OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_POP_JUMP_IF_FALSE, OP_LOOP:
+			target := next + c.u16(pc+1)
+
+			switch op {
+			case OP_LOOP:
+				target = next - c.u16(pc+1)
+			case OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE:
+				pops, pushes = 1, 1
+			case OP_POP_JUMP_IF_FALSE:
+				pops = 1
+			}
+
+			if state.height < pops {
+				return fmt.Errorf("stack underflow at %d", pc)
+			}
+
+			branch := verifyState{state.height - pops + pushes, state.scopes}
+
+			if err := reach(pc, target, branch); err != nil {
+				return err
+			}
+
+			// unconditional jumps do not fall through
+			if op == OP_JUMP || op == OP_LOOP {
+				continue
+			}
+
+		case OP_CALL, OP_TAIL_CALL:
+			_, err = index(pc+1, len(c.tokens), "token")
+			pops, pushes = int(c.code[pc+1])+1, 1
+
+			// tail calls return from the chunk
+			if op == OP_TAIL_CALL && err == nil && state.height >= pops {
+				continue
+			}
+
+		case OP_ASSERT:
+			var idx int
+			idx, err = index(pc, len(c.asserts), "assert")
+			pops = 1
+
+			if err == nil {
+				// a held condition also drops the compared operands
+				held := 1
+				if c.asserts[idx].compare {
+					held = 3
+				}
+
+				if state.height < held {
+					return fmt.Errorf("stack underflow at %d", pc)
+				}
+
+				err = reach(pc, next+c.u16(pc+3), verifyState{state.height - held, state.scopes})
+			}
+
+		case OP_ASSERT_FAIL:
+			var idx int
+			idx, err = index(pc, len(c.asserts), "assert")
+
+			if err == nil {
+				info := c.asserts[idx]
+
+				if info.message {
+					pops++
+				}
+				if info.compare {
+					pops += 2
+				}
+			}
+
+			if err == nil && state.height < pops {
+				return fmt.Errorf("stack underflow at %d", pc)
+			}
+
+			// raises the failure
+			if err == nil {
+				continue
+			}
+
+		case OP_BEGIN_SCOPE:
+			var idx int
+			idx, err = index(pc, len(c.frames), "frame")
+
+			if err == nil {
+				frame := c.frames[idx]
+
+				// the compiler leaves out blocks that share the enclosing scope
+				if frame != nil && len(frame.names) == 0 {
+					return fmt.Errorf("empty scope at %d", pc)
+				}
+				state.scopes = append(state.scopes[:len(state.scopes):len(state.scopes)], frame)
+			}
+
+		case OP_END_SCOPE:
+			if len(state.scopes) <= len(scopes) {
+				return fmt.Errorf("scope ended at %d was not begun", pc)
+			}
+			state.scopes = state.scopes[:len(state.scopes)-1]
+
+		case OP_FUNCTION:
+			var idx int
+			idx, err = index(pc, len(c.functions), "function")
+
+			if err == nil && usedFunctions[idx] {
+				err = fmt.Errorf("function %d declared twice at %d", idx, pc)
+			}
+
+			if err == nil {
+				usedFunctions[idx] = true
+				err = verifyFunction(c.functions[idx], state.scopes, depth)
+			}
+
+		case OP_RETURN:
+			if state.height < 1 {
+				return fmt.Errorf("stack underflow at %d", pc)
+			}
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if state.height < pops {
+			return fmt.Errorf("stack underflow at %d", pc)
+		}
+		state.height += pushes - pops
+
+		if err := reach(pc, next, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check a closure declared where scopes are visible
+func verifyFunction(fn fnProto, scopes []*frameInfo, depth int) error {
+	declaration := fn.declaration
+
+	if declaration.slot >= 0 {
+		if err := checkSlot(declaration.slot, scopes, -1); err != nil {
+			return fmt.Errorf("fn %s: %w", declaration.name.GetLexeme(), err)
+		}
+	}
+
+	// parameters are the first slots of the frame
+	if declaration.frame != nil && len(declaration.params) > len(declaration.frame.names) {
+		return fmt.Errorf("fn %s has more parameters than slots", declaration.name.GetLexeme())
+	}
+
+	inner := append(scopes[:len(scopes):len(scopes)], declaration.frame)
+	err := fn.chunk.verify(inner, depth+1)
+
+	if err != nil {
+		return fmt.Errorf("fn %s: %w", declaration.name.GetLexeme(), err)
+	}
+	return nil
+}
+
+// A resolved local must name a slot of a scope that is entered
+func checkLocal(local localRef, scopes []*frameInfo, pc int) error {
+	// unresolved names are looked up
+	if local.depth < 0 {
+		return nil
+	}
+
+	if local.depth >= len(scopes) {
+		return fmt.Errorf("local %s at %d is outside the scopes", local.name.GetLexeme(), pc)
+	}
+	return checkSlot(local.slot, scopes[:len(scopes)-local.depth], pc)
+}
+
+// A slot must exist in the innermost scope
+func checkSlot(slot int, scopes []*frameInfo, pc int) error {
+	if len(scopes) == 0 || scopes[len(scopes)-1] == nil {
+		return fmt.Errorf("slot %d at %d is in a scope without slots", slot, pc)
+	}
+
+	if slot < 0 || slot >= len(scopes[len(scopes)-1].names) {
+		return fmt.Errorf("slot %d at %d out of range", slot, pc)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package almond
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Decoded bytecode must list and run exactly like the chunk it was written from
+func TestBytecodeRoundTrip(t *testing.T) {
+	for name, src := range differentialScripts {
+		t.Run(name, func(t *testing.T) {
+			chunk, err := compileSource([]byte(src), RunOptions{})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := decodeBytecode(encodeBytecode(chunk, []byte(src), "script.al", true))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want, got bytes.Buffer
+			chunk.Disassemble(&want, name)
+			file.chunk.Disassemble(&got, name)
+
+			if got.String() != want.String() {
+				t.Errorf("listing differs\n--- decoded\n%s\n--- compiled\n%s", got.String(), want.String())
+			}
+
+			if ran, expected := runCompiledChunk(file.chunk), runCompiledChunk(chunk); ran != expected {
+				t.Errorf("run differs\n--- decoded\n%s\n--- compiled\n%s", ran, expected)
+			}
+
+			if file.sourcePath != "script.al" || file.sourceHash != sha256.Sum256([]byte(src)) {
+				t.Errorf("got header %q %x", file.sourcePath, file.sourceHash)
+			}
+		})
+	}
+}
+
+func runCompiledChunk(chunk *Chunk) string {
+	var stdout bytes.Buffer
+	inter, _ := NewInterpreter()
+	inter.SetOutput(&stdout)
+	inter.SetMaxCallDepth(50)
+
+	err := inter.runCompiled(chunk)
+
+	var fault *RuntimeFault
+	if errors.As(err, &fault) {
+		return stdout.String() + fault.Error() + "\n" + fault.StackTrace()
+	}
+	return stdout.String()
+}
+
+func TestBytecodeRejectsBadFiles(t *testing.T) {
+	chunk, _ := compileSource([]byte(`print 1;`), RunOptions{})
+	data := encodeBytecode(chunk, []byte(`print 1;`), "one.al", true)
+
+	if _, err := decodeBytecode([]byte("print 1;")); !errors.Is(err, ErrNotBytecode) {
+		t.Errorf("source: got %v", err)
+	}
+
+	if _, err := decodeBytecode(data[:6]); !errors.Is(err, ErrNotBytecode) {
+		t.Errorf("truncated header: got %v", err)
+	}
+
+	// the header of a newer file still names its source
+	newer := append([]byte{}, data...)
+	newer[7] = BytecodeVersion + 1
+	file, err := decodeBytecode(newer)
+
+	if !errors.Is(err, ErrIncompatibleBytecode) || file.sourcePath != "one.al" {
+		t.Errorf("newer version: got %v", err)
+	}
+
+	damaged := append([]byte{}, data...)
+	damaged[len(damaged)-3] ^= 0xff
+
+	if _, err := decodeBytecode(damaged); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("damaged: got %v", err)
+	}
+}
+
+// Crafted code that passes the checksum is refused before it can reach the vm
+func TestBytecodeRejectsHostileCode(t *testing.T) {
+	src := `fn f(a) {
+		var b = a;
+		{ var c = b; print c; }
+		return b;
+	}
+	print f(1);
+	assert f(2) == 2;`
+
+	cases := map[string]func(c *Chunk){
+		"unknown opcode":     func(c *Chunk) { c.code[0] = 200 },
+		"truncated":          func(c *Chunk) { c.code = append(c.code, byte(OP_CONSTANT)) },
+		"constant":           func(c *Chunk) { c.constants = nil },
+		"token":              func(c *Chunk) { c.tokens = nil },
+		"assert":             func(c *Chunk) { c.asserts = nil },
+		"not an instruction": func(c *Chunk) { c.code = append(c.code, byte(OP_JUMP), 0xff, 0xff) },
+		"stack underflow":    func(c *Chunk) { c.code = append([]byte{byte(OP_POP)}, c.code...) },
+		"was not begun":      func(c *Chunk) { c.code = append(c.code, byte(OP_END_SCOPE)) },
+		"declared twice": func(c *Chunk) {
+			c.code = append(c.code, byte(OP_FUNCTION), 0, 0)
+		},
+		"out of range": func(c *Chunk) { c.functions[0].chunk.locals[0].slot = 99 },
+		"outside the scopes": func(c *Chunk) {
+			c.functions[0].chunk.locals[0].depth = 5
+		},
+		"paths disagree": func(c *Chunk) {
+			// a loop that pushes a value every time round
+			c.code = append(c.code, byte(OP_CONSTANT), 0, 0, byte(OP_LOOP), 0, 6)
+		},
+	}
+
+	for want, corrupt := range cases {
+		chunk, err := compileSource([]byte(src), RunOptions{})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		corrupt(chunk)
+		_, err = decodeBytecode(encodeBytecode(chunk, []byte(src), "f.al", true))
+
+		if !errors.Is(err, ErrIncompatibleBytecode) || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v", want, err)
+		}
+	}
+
+	// a short file claiming a huge list is refused before allocating it
+	body := &encoder{}
+	body.boolean(true)
+	body.u32(1 << 30)
+	data := sealBytecode(body.buf.Bytes())
+
+	if _, err := decodeBytecode(data); !errors.Is(err, ErrIncompatibleBytecode) || !strings.Contains(err.Error(), "invalid length") {
+		t.Errorf("huge length: got %v", err)
+	}
+}
+
+// Code compiled without optimizing passes the checks as well
+func TestUnoptimizedCodeVerifies(t *testing.T) {
+	for name, src := range differentialScripts {
+		chunk, err := compileSource([]byte(src), RunOptions{NoOptimize: true})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := chunk.verify(nil, 0); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// Wrap an encoded body in a header with a matching checksum
+func sealBytecode(body []byte) []byte {
+	out := &encoder{}
+	out.buf.Write(bytecodeMagic[:])
+	out.u32(BytecodeVersion)
+	out.buf.Write(make([]byte, sha256.Size))
+	out.str("f.al")
+	out.u32(crc32.ChecksumIEEE(body))
+	out.buf.Write(body)
+	return out.buf.Bytes()
+}
+
+// Editing the source makes the bytecode stale, running it recompiles and rewrites it
+func TestStaleBytecodeIsRecompiled(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "app.al")
+	output := filepath.Join(dir, "build", "app.alc")
+	os.Mkdir(filepath.Dir(output), 0o755)
+	os.WriteFile(source, []byte(`var x = 1;`), 0o644)
+
+	if err := CompileFile(source, output, RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := []byte(`var x = 2;`)
+	os.WriteFile(source, edited, 0o644)
+
+	if err := runBytecodeFile(output, RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(output)
+	file, err := decodeBytecode(data)
+
+	if err != nil || file.sourceHash != sha256.Sum256(edited) || file.sourcePath != filepath.Join("..", "app.al") {
+		t.Errorf("got %q %x, %v", file.sourcePath, file.sourceHash, err)
+	}
+}
+
+// Running bytecode with other optimize settings than it was compiled with recompiles it
+func TestOptimizeSettingMakesBytecodeStale(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "app.al")
+	output := filepath.Join(dir, "app.alc")
+	os.WriteFile(source, []byte(`var x = 1 + 2;`), 0o644)
+
+	optimized := func() bool {
+		data, _ := os.ReadFile(output)
+		file, err := decodeBytecode(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file.optimized
+	}
+
+	if err := CompileFile(source, output, RunOptions{}); err != nil || !optimized() {
+		t.Fatalf("compile: got %v", err)
+	}
+
+	if err := runBytecodeFile(output, RunOptions{NoOptimize: true}); err != nil || optimized() {
+		t.Errorf("-no-opt run: got %v, optimized %v", err, optimized())
+	}
+
+	if err := runBytecodeFile(output, RunOptions{}); err != nil || !optimized() {
+		t.Errorf("optimized run: got %v, optimized %v", err, optimized())
+	}
+}
+
+func TestCompileFileRejects(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "app.al")
+	os.WriteFile(source, []byte(`var x = 1;`), 0o644)
+
+	link := filepath.Join(dir, "link.al")
+	os.Symlink(source, link)
+
+	cases := []struct {
+		filename, output, want string
+	}{
+		{filepath.Join(dir, "app.alc"), filepath.Join(dir, "other.alc"), "already bytecode"},
+		{source, source, "would overwrite the source"},
+		{source, filepath.Join(dir, ".", "app.al"), "would overwrite the source"},
+		{source, link, "would overwrite the source"},
+	}
+
+	for _, c := range cases {
+		if err := CompileFile(c.filename, c.output, RunOptions{}); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s -o %s: got %v", c.filename, c.output, err)
+		}
+	}
+
+	if data, _ := os.ReadFile(source); string(data) != `var x = 1;` {
+		t.Errorf("source was overwritten: %q", data)
+	}
+}
@@ -8,7 +8,7 @@ import (
 
 type Callable interface {
 	Arity() int
-	Call(env Environment, args []Object) (Object, error)
+	Call(env *Environment, args []Object) (Object, error)
 	ToString() string
 }
 
@@ -25,11 +25,9 @@ func (n *NativeClock) Arity() int {
 	return 0
 }
 
-func (n *NativeClock) Call(env Environment, args []Object) (Object, error) {
+func (n *NativeClock) Call(env *Environment, args []Object) (Object, error) {
 	elapsed := float64(time.Since(n.start).Nanoseconds()) / 1e9
-	timeObj := NewObject(NUMBER, elapsed)
-
-	return *timeObj, nil
+	return numberValue(elapsed), nil
 }
 
 func (n *NativeClock) ToString() string {
@@ -42,42 +40,69 @@ type NativeSleep struct{}
 func NewNativeSleep() *NativeSleep      { return &NativeSleep{} }
 func (n *NativeSleep) Arity() int       { return 1 }
 func (n *NativeSleep) ToString() string { return "<NATIVE SLEEP FN>" }
-func (n *NativeSleep) Call(env Environment, args []Object) (Object, error) {
+func (n *NativeSleep) Call(env *Environment, args []Object) (Object, error) {
 	arg := args[0]
 
-	dt_ms, ok := arg.literal.(float64)
+	dt_ms := arg.num
 
-	if !ok {
-		fmt.Print("sleepMS usage error: must supply a number!")
-		return Object{}, errors.New("sleep argument must be type number")
+	if arg.kind != kindNumber {
+		return Object{}, errors.New("sleepMS usage error: must supply a number")
 	}
 
-	time.Sleep(time.Duration(dt_ms) * time.Millisecond)
-	return *NewObject(NULL, nil), nil
+	timer := time.NewTimer(time.Duration(dt_ms) * time.Millisecond)
+	defer timer.Stop()
+
+	// wake early if the run is cancelled
+	select {
+	case <-timer.C:
+	case <-env.inter.done():
+		return Object{}, env.inter.cause()
+	}
+	return nullValue(), nil
 }
 
 // Create a user function callable
 type FunctionCall struct {
 	declaration FnStmt
+	closure     *Environment
+	// bytecode for the body, nil when declared by the tree-walker
+	chunk *Chunk
 }
 
-func NewFunctionCall(d FnStmt) *FunctionCall {
-	return &FunctionCall{d}
+func NewFunctionCall(d FnStmt, closure *Environment) *FunctionCall {
+	closure.capture()
+	return &FunctionCall{d, closure, nil}
 }
 
-func (f *FunctionCall) Call(env Environment, args []Object) (Object, error) {
-	// copy environment
-	copy := env
-
-	fnEnv := NewEnclosedEnv(&copy)
+func (f *FunctionCall) Call(env *Environment, args []Object) (Object, error) {
+	// body runs in the scope the function was declared in
+	var fnEnv *Environment
 	var err error = nil
 
-	for idx, param := range f.declaration.params {
-		fnEnv.Define(param.lexeme, args[idx])
+	if f.declaration.frame != nil {
+		// parameters are the first slots
+		fnEnv = newFrame(f.closure, f.declaration.frame)
+
+		for idx, arg := range args {
+			fnEnv.setSlot(idx, arg)
+		}
+	} else {
+		fnEnv = NewEnclosedEnv(f.closure)
+
+		for idx, param := range f.declaration.params {
+			fnEnv.Define(param.lexeme, args[idx])
+		}
+	}
+
+	defer fnEnv.inter.release(fnEnv)
+
+	// functions loaded from bytecode have no body to walk
+	if f.chunk != nil && (!fnEnv.inter.walking() || f.declaration.body == nil) {
+		return fnEnv.inter.runChunk(f.chunk, fnEnv)
 	}
 
 	for _, statement := range f.declaration.body {
-		err = statement.Evaluate(fnEnv)
+		err = fnEnv.inter.execute(statement, fnEnv)
 
 		if err != nil {
 			break
@@ -90,10 +115,10 @@ func (f *FunctionCall) Call(env Environment, args []Object) (Object, error) {
 		if ok {
 			return *val, nil
 		}
-		fmt.Print(err)
+		return Object{}, err
 	}
 
-	return *NewObject(NULL, nil), nil
+	return nullValue(), nil
 }
 
 func (f *FunctionCall) Arity() int {
@@ -103,3 +128,144 @@ func (f *FunctionCall) Arity() int {
 func (f *FunctionCall) ToString() string {
 	return "<fn " + f.declaration.name.lexeme + ">"
 }
+
+// Call with arity checks, budgets and depth tracking, errors point at tok.
+// Tail calls returned by the callee run here in a loop without nesting.
+func callFunction(e *Environment, function Callable, args []Object, tok Token) (Object, error) {
+	err := checkArity(function, args, tok)
+
+	if err != nil {
+		return Object{}, err
+	}
+
+	// guard against runaway recursion
+	inter := e.inter
+	if inter != nil {
+		err := inter.checkpoint(tok)
+
+		if err != nil {
+			return Object{}, err
+		}
+
+		if inter.maxDepth > 0 && inter.depth >= inter.maxDepth {
+			return Object{}, RuntimeError("stack overflow in "+callName(function), tok)
+		}
+		inter.depth++
+		defer func() { inter.depth-- }()
+	}
+
+	if inter != nil && inter.hooks != nil {
+		inter.hooks.EnterCall(Position{tok.GetLine()}, callName(function), args, EnvView{e})
+	}
+
+	value, err := function.Call(e, args)
+
+	// each tail call replaces the frame of the function that made it,
+	// traces keep the call that created the frame
+	site := tok
+	elided := 0
+	for tail, ok := err.(*tailCall); ok; tail, ok = err.(*tailCall) {
+		err = checkArity(tail.function, tail.args, tail.tok)
+
+		if err == nil {
+			err = inter.checkpoint(tail.tok)
+		}
+
+		if err != nil {
+			break
+		}
+
+		// hooks see the replaced frame leave before the callee enters
+		if inter != nil && inter.hooks != nil {
+			inter.hooks.ExitCall(Position{tok.GetLine()}, callName(function), nullValue(), EnvView{e})
+			inter.hooks.EnterCall(Position{tail.tok.GetLine()}, callName(tail.function), tail.args, EnvView{e})
+		}
+
+		function, tok = tail.function, tail.tok
+		elided++
+		value, err = function.Call(e, tail.args)
+	}
+
+	if inter != nil && inter.hooks != nil {
+		if val, ok := err.(*Object); ok {
+			inter.hooks.ExitCall(Position{tok.GetLine()}, callName(function), *val, EnvView{e})
+		} else if err == nil {
+			inter.hooks.ExitCall(Position{tok.GetLine()}, callName(function), value, EnvView{e})
+		}
+	}
+
+	// strings made by natives count against the memory limit
+	if _, ok := function.(*FunctionCall); !ok && err == nil {
+		err = inter.adopt(value)
+	}
+
+	if err != nil {
+		switch fault := err.(type) {
+		case *Object:
+			return value, err
+		case *RuntimeFault:
+			fault.addFrame(callName(function), site.GetLine(), elided)
+			return value, err
+		}
+
+		// a script function the native called back into already has its line
+		var fault *RuntimeFault
+		if errors.As(err, &fault) {
+			fault.addFrame(callName(function), site.GetLine(), elided)
+			return nullValue(), fault
+		}
+
+		// native errors are raised at the call site
+		return nullValue(), RuntimeErrorCause(err, tok)
+	}
+	return value, nil
+}
+
+// Report a call with the wrong number of arguments
+func checkArity(function Callable, args []Object, tok Token) error {
+	// negative arity accepts any number of arguments
+	if function.Arity() >= 0 && function.Arity() != len(args) {
+		return RuntimeError(fmt.Sprintf(
+			"expected %v arguments, but recieved %v",
+			function.Arity(), len(args)), tok)
+	}
+	return nil
+}
+
+// Call made by `return f(x);` inside a function. It travels as an error
+// like return values do, and callFunction makes it in place of the frame
+// that returned it.
+type tailCall struct {
+	function *FunctionCall
+	args     []Object
+	tok      Token
+}
+
+func (t *tailCall) Error() string {
+	return "tail call to " + callName(t.function)
+}
+
+// Return the result of a call in tail position, only script functions
+// are deferred to the caller, natives are called in place
+func returnCall(e *Environment, function Callable, args []Object, tok Token) error {
+	fn, ok := function.(*FunctionCall)
+
+	if ok {
+		return &tailCall{fn, args, tok}
+	}
+
+	value, err := callFunction(e, function, args, tok)
+
+	if err != nil {
+		return err
+	}
+	return &value
+}
+
+// Name of a callable used in error messages
+func callName(c Callable) string {
+	if f, ok := c.(*FunctionCall); ok {
+		return "fn " + f.declaration.name.lexeme
+	}
+	return c.ToString()
+}
@@ -0,0 +1,161 @@
+package almond
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// Calls in tail position reuse the frame, so they pass the call depth limit
+func TestTailCallsRunInConstantStack(t *testing.T) {
+	src := `
+		fn count(n, total) { if (n == 0) return total; return count(n - 1, total + 1); }
+		fn even(n) { if (n == 0) return true; return odd(n - 1); }
+		fn odd(n) { if (n == 0) return false; return even(n - 1); }
+		print count(1000000, 0);
+		print even(1000001);`
+
+	for _, treeWalk := range []bool{false, true} {
+		var stdout bytes.Buffer
+		engine, _ := NewEngine(Options{Stdout: &stdout, Stderr: io.Discard, TreeWalk: treeWalk})
+
+		_, err := engine.Eval(src)
+
+		if err != nil {
+			t.Fatalf("tree-walk %v: %v", treeWalk, err)
+		}
+
+		if got := stdout.String(); got != "1000000\nFALSE\n" {
+			t.Errorf("tree-walk %v: got %q", treeWalk, got)
+		}
+	}
+}
+
+// Calls that are not in tail position still count against the limit
+func TestNonTailCallsOverflow(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, MaxCallDepth: 100})
+
+	_, err := engine.Eval(`fn f(n) { if (n == 0) return 0; var r = f(n - 1); return r; } f(1000);`)
+
+	if err == nil || !strings.Contains(err.Error(), "stack overflow in fn f") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestTraceNotesElidedFrames(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+
+	_, err := engine.Eval(`
+		fn fail() { return missing; }
+		fn loop(n) { if (n == 0) return fail(); return loop(n - 1); }
+		fn main() { var v = loop(1000); return v; }
+		main();`)
+
+	var fault *RuntimeFault
+	if !errors.As(err, &fault) {
+		t.Fatalf("got %v", err)
+	}
+
+	// the frame main's call created ends up running fail
+	want := []TraceFrame{
+		{"fn fail", 4, 1001},
+		{"fn main", 5, 0},
+	}
+
+	if len(fault.Trace) != len(want) {
+		t.Fatalf("got trace\n%s", fault.StackTrace())
+	}
+
+	for idx, frame := range want {
+		if fault.Trace[idx] != frame {
+			t.Errorf("frame %d: got %+v, want %+v", idx, fault.Trace[idx], frame)
+		}
+	}
+
+	if !strings.Contains(fault.StackTrace(), "in fn fail called at line 4 (1001 tail calls elided)") {
+		t.Errorf("got trace\n%s", fault.StackTrace())
+	}
+}
+
+// Hooked runs keep tail calls, each replaced frame exits before its callee enters
+func TestHooksSeeTailCalls(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+	hooks := &callCounter{}
+	engine.inter.SetHooks(hooks)
+
+	value, err := engine.Eval(`fn down(n) { if (n == 0) return "done"; return down(n - 1); } down(100000);`)
+
+	if err != nil || value.String() != "done" {
+		t.Fatalf("got %s, %v", value.String(), err)
+	}
+
+	if hooks.enter != 100001 || hooks.exit != 100001 || hooks.deepest != 1 {
+		t.Errorf("got %d enters, %d exits, %d deep", hooks.enter, hooks.exit, hooks.deepest)
+	}
+
+	// replaced frames hand over without a value, the last returns it
+	if hooks.results[0] != "NULL" || hooks.results[len(hooks.results)-1] != "done" {
+		t.Errorf("got results %s ... %s", hooks.results[0], hooks.results[len(hooks.results)-1])
+	}
+
+	_, err = engine.Eval(`fn a() { return b(); } fn b() { return missing; }
+		a();`)
+
+	if err == nil || !strings.Contains(err.(*RuntimeFault).StackTrace(), "in fn b called at line 2 (1 tail call elided)") {
+		t.Errorf("hooked trace: got %v", err)
+	}
+}
+
+type callCounter struct {
+	BaseHooks
+	enter, exit    int
+	depth, deepest int
+	results        []string
+}
+
+func (c *callCounter) EnterCall(pos Position, name string, args []Value, env EnvView) {
+	c.enter++
+	c.depth++
+	c.deepest = max(c.deepest, c.depth)
+}
+
+func (c *callCounter) ExitCall(pos Position, name string, result Value, env EnvView) {
+	c.exit++
+	c.depth--
+	c.results = append(c.results, result.String())
+}
+
+// Function bodies see the scope they were declared in, not their caller's
+func TestFunctionsAreLexicallyScoped(t *testing.T) {
+	var stdout bytes.Buffer
+	engine, _ := NewEngine(Options{Stdout: &stdout, Stderr: io.Discard})
+
+	_, err := engine.Eval(`
+		fn counter() {
+			var n = 0;
+			fn next() { n = n + 1; return n; }
+			return next;
+		}
+		var count = counter();
+		count();
+		print count();`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := stdout.String(); got != "2\n" {
+		t.Errorf("closure: got %q", got)
+	}
+
+	_, err = engine.Eval(`
+		fn inner() { return local; }
+		fn outer() { var local = 5; return inner(); }
+		outer();`)
+
+	if err == nil || !strings.Contains(err.Error(), "Undefined variable 'local'") {
+		t.Errorf("caller's local: got %v", err)
+	}
+}
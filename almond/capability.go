@@ -0,0 +1,193 @@
+package almond
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Native modules a script is allowed to use
+type Capabilities struct {
+	// clock
+	Time bool
+	// sleepMS
+	Sleep bool
+	// readFile and writeFile, confined to FileRoot
+	FileSystem bool
+	// Directory file natives are confined to, empty means the working directory
+	FileRoot string
+	// getEnv
+	Env bool
+	// exec, runs a program directly without a shell
+	Exec bool
+}
+
+// Natives installed by NewEnv
+var DefaultCapabilities = Capabilities{Time: true, Sleep: true}
+
+// Every native module, the command line tool grants it with -allow all
+var AllCapabilities = Capabilities{Time: true, Sleep: true, FileSystem: true, Env: true, Exec: true}
+
+// Raised when a script calls a native it was not granted
+var ErrCapability = errors.New("capability not granted")
+
+// Stand-in for a native whose capability was not granted
+type deniedNative struct {
+	name       string
+	capability string
+}
+
+func (d *deniedNative) Arity() int       { return -1 }
+func (d *deniedNative) ToString() string { return "<denied fn " + d.name + ">" }
+func (d *deniedNative) Call(env *Environment, args []Object) (Object, error) {
+	return Object{}, fmt.Errorf("%w: %s needs the %s capability", ErrCapability, d.name, d.capability)
+}
+
+// Install granted natives, denied ones fail with ErrCapability when called
+func (c Capabilities) install(env *Environment) {
+	define := func(granted bool, capability, name string, native Callable) {
+		if !granted {
+			native = &deniedNative{name, capability}
+		}
+		env.Define(name, callableValue(native))
+	}
+
+	define(c.Time, "time", "clock", NewNativeClock())
+	define(c.Sleep, "sleep", "sleepMS", NewNativeSleep())
+
+	readFile, _ := NewNativeFunc("readFile", c.readFile)
+	writeFile, _ := NewNativeFunc("writeFile", c.writeFile)
+	define(c.FileSystem, "filesystem", "readFile", readFile)
+	define(c.FileSystem, "filesystem", "writeFile", writeFile)
+
+	getEnv, _ := NewNativeFunc("getEnv", getEnv)
+	define(c.Env, "env", "getEnv", getEnv)
+
+	define(c.Exec, "exec", "exec", &NativeExec{})
+}
+
+// ---- File system natives
+
+// Map a script path into FileRoot, rejecting paths that escape it
+func (c Capabilities) resolve(path string) (string, error) {
+	root, err := filepath.Abs(c.FileRoot)
+
+	if err != nil {
+		return "", err
+	}
+
+	// absolute script paths are still taken relative to the root
+	full := filepath.Join(root, path)
+
+	// follow links in the parent directory before checking the bounds
+	realRoot, err := filepath.EvalSymlinks(root)
+
+	if err != nil {
+		return "", err
+	}
+
+	realDir, err := filepath.EvalSymlinks(filepath.Dir(full))
+
+	if err != nil {
+		return "", err
+	}
+
+	real := filepath.Join(realDir, filepath.Base(full))
+
+	// follow a link in the last component, refusing ones that lead nowhere
+	// since writing through them would create their target wherever it is
+	if info, err := os.Lstat(real); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		target, err := filepath.EvalSymlinks(real)
+
+		if err != nil {
+			return "", errors.New("path " + path + " is a broken link")
+		}
+		real = target
+	}
+
+	rel, err := filepath.Rel(realRoot, real)
+
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("path " + path + " is outside the file root")
+	}
+	return real, nil
+}
+
+func (c Capabilities) readFile(path string) (string, error) {
+	full, err := c.resolve(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(full)
+	return string(data), err
+}
+
+func (c Capabilities) writeFile(path string, text string) error {
+	full, err := c.resolve(path)
+
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(full, []byte(text), 0o644)
+}
+
+// ---- Environment natives
+
+// Read an environment variable, null when it is not set
+func getEnv(name string) any {
+	value, ok := os.LookupEnv(name)
+
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+// ---- Process natives
+
+// Run a command and return its combined output. A single string is split on
+// spaces, quotes are not understood, so exec("grep", "a b", "file") passes
+// arguments that contain spaces as separate strings.
+type NativeExec struct{}
+
+func (n *NativeExec) Arity() int       { return -1 }
+func (n *NativeExec) ToString() string { return "<NATIVE EXEC FN>" }
+func (n *NativeExec) Call(env *Environment, args []Object) (Object, error) {
+	var fields []string
+
+	for _, arg := range args {
+		str, ok := arg.ref.(string)
+
+		if !ok {
+			return Object{}, errors.New("exec usage error: must supply a command string")
+		}
+		fields = append(fields, str)
+	}
+
+	if len(fields) == 1 {
+		fields = strings.Fields(fields[0])
+	}
+
+	if len(fields) == 0 || fields[0] == "" {
+		return Object{}, errors.New("exec usage error: empty command")
+	}
+
+	// stop the process with the run
+	ctx := context.Background()
+	if env.inter != nil && env.inter.ctx != nil {
+		ctx = env.inter.ctx
+	}
+
+	output, err := exec.CommandContext(ctx, fields[0], fields[1:]...).CombinedOutput()
+
+	if err != nil {
+		return Object{}, errors.New("exec " + fields[0] + ": " + err.Error())
+	}
+	return stringValue(string(output)), nil
+}
@@ -0,0 +1,210 @@
+package almond
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// File root with a file inside it and a secret next to it
+func fileRoot(t *testing.T) (root, outside string) {
+	dir := t.TempDir()
+	root = filepath.Join(dir, "root")
+	outside = filepath.Join(dir, "outside")
+
+	for _, path := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		filepath.Join(root, "a.txt"):         "inside",
+		filepath.Join(outside, "secret.txt"): "secret",
+	}
+
+	for path, text := range files {
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, outside
+}
+
+func TestResolveStaysInFileRoot(t *testing.T) {
+	root, outside := fileRoot(t)
+	caps := Capabilities{FileSystem: true, FileRoot: root}
+
+	links := map[string]string{
+		"out":      outside,
+		"leak.txt": filepath.Join(outside, "secret.txt"),
+		"ok.txt":   filepath.Join(root, "a.txt"),
+		"dangling": filepath.Join(outside, "new.txt"),
+	}
+
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symlinks unavailable:", err)
+		}
+	}
+
+	realRoot, _ := filepath.EvalSymlinks(root)
+
+	allowed := map[string]string{
+		"a.txt":        "a.txt",
+		"sub/../a.txt": "a.txt",
+		"/a.txt":       "a.txt",
+		"ok.txt":       "a.txt",
+		"sub/new.txt":  "sub/new.txt",
+	}
+
+	for path, want := range allowed {
+		got, err := caps.resolve(path)
+
+		if err != nil || got != filepath.Join(realRoot, want) {
+			t.Errorf("%s: got %q, %v", path, got, err)
+		}
+	}
+
+	escapes := []string{
+		"..",
+		"../outside/secret.txt",
+		"sub/../../outside/secret.txt",
+		"/../outside/secret.txt",
+		"out/secret.txt",
+		"leak.txt",
+	}
+
+	for _, path := range escapes {
+		if got, err := caps.resolve(path); err == nil || !strings.Contains(err.Error(), "outside the file root") {
+			t.Errorf("%s: got %q, %v", path, got, err)
+		}
+	}
+
+	// a link to a file that does not exist yet could be written through
+	if got, err := caps.resolve("dangling"); err == nil || !strings.Contains(err.Error(), "broken link") {
+		t.Errorf("dangling link: got %q, %v", got, err)
+	}
+
+	// a missing directory is an error, not a path outside the root
+	if _, err := caps.resolve("missing/a.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing dir: got %v", err)
+	}
+}
+
+func TestFileNatives(t *testing.T) {
+	root, outside := fileRoot(t)
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Capabilities: &Capabilities{FileSystem: true, FileRoot: root}})
+
+	value, err := engine.Eval(`writeFile("sub/b.txt", readFile("a.txt") + "!"); readFile("/sub/b.txt");`)
+
+	if err != nil || value.String() != "inside!" {
+		t.Fatalf("got %s, %v", value.String(), err)
+	}
+
+	if _, err := engine.Eval(`writeFile("../outside/secret.txt", "gone");`); err == nil || !strings.Contains(err.Error(), "outside the file root") {
+		t.Errorf("write escape: got %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(outside, "secret.txt")); string(data) != "secret" {
+		t.Errorf("secret was overwritten: %q", data)
+	}
+
+	if err := os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(root, "dangling")); err != nil {
+		t.Skip("symlinks unavailable:", err)
+	}
+
+	if _, err := engine.Eval(`writeFile("dangling", "planted");`); err == nil {
+		t.Error("wrote through a dangling link")
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file created outside the root: %v", err)
+	}
+}
+
+func TestExecNative(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Capabilities: &Capabilities{Exec: true}})
+
+	values := map[string]string{
+		// a single command is split on spaces
+		`exec("echo a   b");`: "a b\n",
+		// separate arguments are passed as they are
+		`exec("echo", "two   words");`: "two   words\n",
+	}
+
+	for src, want := range values {
+		if value, err := engine.Eval(src); err != nil || value.String() != want {
+			t.Errorf("%s: got %q, %v", src, value.String(), err)
+		}
+	}
+
+	failures := map[string]string{
+		`exec("");`:        "empty command",
+		`exec();`:          "empty command",
+		`exec("echo", 1);`: "must supply a command string",
+		`exec("false");`:   "exec false",
+	}
+
+	for src, want := range failures {
+		if _, err := engine.Eval(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+}
+
+// Natives that were not granted are still defined and fail when called
+func TestDeniedNatives(t *testing.T) {
+	calls := map[string]string{
+		"clock":     `clock();`,
+		"sleepMS":   `sleepMS(1);`,
+		"readFile":  `readFile("a.txt");`,
+		"writeFile": `writeFile("a.txt", "x");`,
+		"getEnv":    `getEnv("HOME");`,
+		"exec":      `exec("true");`,
+	}
+
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Capabilities: &Capabilities{}})
+
+	for name, src := range calls {
+		value, ok := engine.GetGlobal(name)
+
+		if !ok || value.GetKindStr() != "CALLABLE" {
+			t.Errorf("%s: not defined", name)
+		}
+
+		if _, err := engine.Eval(src); !errors.Is(err, ErrCapability) || !strings.Contains(err.Error(), name+" needs the") {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	// the defaults only grant the clock and sleep
+	engine, _ = NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+
+	for name, src := range calls {
+		_, err := engine.Eval(src)
+
+		if granted := name == "clock" || name == "sleepMS"; granted != (err == nil) {
+			t.Errorf("default %s: got %v", name, err)
+		}
+	}
+}
+
+func TestEnvNative(t *testing.T) {
+	t.Setenv("ALMOND_TEST_VAR", "set")
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Capabilities: &Capabilities{Env: true}})
+
+	values := map[string]string{
+		`getEnv("ALMOND_TEST_VAR");`:   "set",
+		`getEnv("ALMOND_TEST_UNSET");`: "NULL",
+	}
+
+	for src, want := range values {
+		if value, err := engine.Eval(src); err != nil || value.String() != want {
+			t.Errorf("%s: got %s, %v", src, value.String(), err)
+		}
+	}
+}
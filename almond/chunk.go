@@ -0,0 +1,218 @@
+package almond
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Bytecode instruction, operands follow the opcode in big endian
+type OpCode byte
+
+const (
+	// Values
+	OP_CONSTANT OpCode = iota // u16 constant -> push constant
+	OP_POP                    // discard the top value
+	OP_RESULT                 // pop into the script result
+
+	// Variables, the operand is the name token
+	OP_GET      // u16 token -> push variable
+	OP_SET      // u16 token -> assign the top value, keeping it
+	OP_DEFINE   // u16 token -> pop into a new variable
+	OP_PROPERTY // u16 token -> replace a host object with its property
+
+	// Resolved locals
+	OP_GET_LOCAL    // u16 local -> push the slot
+	OP_SET_LOCAL    // u16 local -> assign the top value to the slot, keeping it
+	OP_DEFINE_LOCAL // u16 slot -> pop into a slot of the current scope
+
+	// Operators, the operand is the operator token
+	OP_UNARY   // u16 token -> replace the top value
+	OP_BINARY  // u16 token -> pop left then right, push the result
+	OP_COMPARE // u16 token -> like OP_BINARY but keeps both operands
+
+	// Control flow, jumps are relative to the next instruction
+	OP_JUMP              // u16 offset -> jump forward
+	OP_JUMP_IF_FALSE     // u16 offset -> jump forward if the top is falsy, keeping it
+	OP_JUMP_IF_TRUE      // u16 offset -> jump forward if the top is truthy, keeping it
+	OP_POP_JUMP_IF_FALSE // u16 offset -> pop and jump forward if falsy
+	OP_LOOP              // u16 offset -> jump backward
+	OP_CHECKPOINT        // u16 token -> count a loop iteration against the budgets
+
+	// Statements
+	OP_CALL        // u8 argc, u16 token -> call the callee below the arguments
+	OP_TAIL_CALL   // u8 argc, u16 token -> return the call, script functions reuse the frame
+	OP_PRINT       // pop and print
+	OP_ASSERT      // u16 assert, u16 offset -> pop the condition and jump forward if it held
+	OP_ASSERT_FAIL // u16 assert -> raise the failed assertion
+	OP_BEGIN_SCOPE // u16 frame -> enter a block scope
+	OP_END_SCOPE   // leave a block scope
+	OP_FUNCTION    // u16 function -> define a closure over the current scope
+	OP_RETURN      // pop and return from the chunk
+)
+
+var opNames = map[OpCode]string{
+	OP_CONSTANT:          "CONSTANT",
+	OP_POP:               "POP",
+	OP_RESULT:            "RESULT",
+	OP_GET:               "GET",
+	OP_SET:               "SET",
+	OP_DEFINE:            "DEFINE",
+	OP_PROPERTY:          "PROPERTY",
+	OP_GET_LOCAL:         "GET_LOCAL",
+	OP_SET_LOCAL:         "SET_LOCAL",
+	OP_DEFINE_LOCAL:      "DEFINE_LOCAL",
+	OP_UNARY:             "UNARY",
+	OP_BINARY:            "BINARY",
+	OP_COMPARE:           "COMPARE",
+	OP_JUMP:              "JUMP",
+	OP_JUMP_IF_FALSE:     "JUMP_IF_FALSE",
+	OP_JUMP_IF_TRUE:      "JUMP_IF_TRUE",
+	OP_POP_JUMP_IF_FALSE: "POP_JUMP_IF_FALSE",
+	OP_LOOP:              "LOOP",
+	OP_CHECKPOINT:        "CHECKPOINT",
+	OP_CALL:              "CALL",
+	OP_TAIL_CALL:         "TAIL_CALL",
+	OP_PRINT:             "PRINT",
+	OP_ASSERT:            "ASSERT",
+	OP_ASSERT_FAIL:       "ASSERT_FAIL",
+	OP_BEGIN_SCOPE:       "BEGIN_SCOPE",
+	OP_END_SCOPE:         "END_SCOPE",
+	OP_FUNCTION:          "FUNCTION",
+	OP_RETURN:            "RETURN",
+}
+
+func (op OpCode) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("OP(%d)", byte(op))
+}
+
+// Bytes of operands following each opcode
+func (op OpCode) operandSize() int {
+	switch op {
+	case OP_CONSTANT, OP_GET, OP_SET, OP_DEFINE, OP_PROPERTY, OP_UNARY, OP_BINARY, OP_COMPARE,
+		OP_GET_LOCAL, OP_SET_LOCAL, OP_DEFINE_LOCAL,
+		OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_POP_JUMP_IF_FALSE, OP_LOOP, OP_CHECKPOINT,
+		OP_ASSERT_FAIL, OP_BEGIN_SCOPE, OP_FUNCTION:
+		return 2
+	case OP_CALL, OP_TAIL_CALL:
+		return 3
+	case OP_ASSERT:
+		return 4
+	}
+	return 0
+}
+
+// Line where a run of instructions starts
+type lineStart struct {
+	offset int
+	line   int
+}
+
+// Function declared inside a chunk
+type fnProto struct {
+	declaration FnStmt
+	chunk       *Chunk
+}
+
+// Resolved variable reference
+type localRef struct {
+	depth int
+	slot  int
+	name  Token
+}
+
+// Compile time details of an assert statement
+type assertInfo struct {
+	keyword Token
+	source  string
+	compare bool
+	message bool
+}
+
+// Compiled code of a script or function body.
+// Chunks are never modified after compiling and may be shared.
+type Chunk struct {
+	code      []byte
+	lines     []lineStart
+	constants []Object
+	tokens    []Token
+	locals    []localRef
+	frames    []*frameInfo
+	functions []fnProto
+	asserts   []assertInfo
+}
+
+// Append an instruction from line
+func (c *Chunk) emit(line int, op OpCode, operands ...byte) int {
+	if len(c.lines) == 0 || c.lines[len(c.lines)-1].line != line {
+		c.lines = append(c.lines, lineStart{len(c.code), line})
+	}
+
+	offset := len(c.code)
+	c.code = append(c.code, byte(op))
+	c.code = append(c.code, operands...)
+	return offset
+}
+
+// Source line of the instruction at offset
+func (c *Chunk) lineAt(offset int) int {
+	idx := sort.Search(len(c.lines), func(n int) bool { return c.lines[n].offset > offset })
+
+	if idx == 0 {
+		return 0
+	}
+	return c.lines[idx-1].line
+}
+
+// Read the u16 operand at offset
+func (c *Chunk) u16(offset int) int {
+	return int(c.code[offset])<<8 | int(c.code[offset+1])
+}
+
+// Write a listing of the chunk and its functions, for debugging
+func (c *Chunk) Disassemble(w io.Writer, name string) {
+	fmt.Fprintf(w, "== %s ==\n", name)
+
+	for offset := 0; offset < len(c.code); {
+		op := OpCode(c.code[offset])
+		fmt.Fprintf(w, "%04d %4d %-18s", offset, c.lineAt(offset), op)
+
+		switch op {
+		case OP_CONSTANT:
+			fmt.Fprintf(w, " %s", valueSource(c.constants[c.u16(offset+1)]))
+		case OP_GET, OP_SET, OP_DEFINE, OP_PROPERTY, OP_UNARY, OP_BINARY, OP_COMPARE, OP_CHECKPOINT:
+			fmt.Fprintf(w, " %s", c.tokens[c.u16(offset+1)].GetLexeme())
+		case OP_JUMP, OP_JUMP_IF_FALSE, OP_JUMP_IF_TRUE, OP_POP_JUMP_IF_FALSE:
+			fmt.Fprintf(w, " -> %04d", offset+3+c.u16(offset+1))
+		case OP_LOOP:
+			fmt.Fprintf(w, " -> %04d", offset+3-c.u16(offset+1))
+		case OP_GET_LOCAL, OP_SET_LOCAL:
+			local := c.locals[c.u16(offset+1)]
+			fmt.Fprintf(w, " %s (%d, %d)", local.name.GetLexeme(), local.depth, local.slot)
+		case OP_DEFINE_LOCAL:
+			fmt.Fprintf(w, " %d", c.u16(offset+1))
+		case OP_BEGIN_SCOPE:
+			if frame := c.frames[c.u16(offset+1)]; frame != nil {
+				fmt.Fprintf(w, " %v", frame.names)
+			}
+		case OP_CALL, OP_TAIL_CALL:
+			fmt.Fprintf(w, " %d", c.code[offset+1])
+		case OP_ASSERT:
+			fmt.Fprintf(w, " %s -> %04d", c.asserts[c.u16(offset+1)].source, offset+5+c.u16(offset+3))
+		case OP_ASSERT_FAIL:
+			fmt.Fprintf(w, " %s", c.asserts[c.u16(offset+1)].source)
+		case OP_FUNCTION:
+			fmt.Fprintf(w, " %s", c.functions[c.u16(offset+1)].declaration.name.GetLexeme())
+		}
+
+		fmt.Fprintln(w)
+		offset += 1 + op.operandSize()
+	}
+
+	for _, fn := range c.functions {
+		fn.chunk.Disassemble(w, "fn "+fn.declaration.name.GetLexeme())
+	}
+}
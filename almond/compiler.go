@@ -0,0 +1,328 @@
+package almond
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Largest index or jump that fits an operand
+const maxOperand = 1<<16 - 1
+
+// Code that does not fit the bytecode format runs on the tree-walker instead
+var errChunkTooLarge = errors.New("program too large to compile")
+
+// key for deduplicating constants, numbers are compared by bits so -0 stays apart from 0
+type constantKey struct {
+	kind valueKind
+	bits uint64
+	text string
+}
+
+// Translates parsed statements into a chunk
+type compiler struct {
+	chunk     *Chunk
+	constants map[constantKey]int
+	err       error
+}
+
+func newCompiler() *compiler {
+	return &compiler{chunk: &Chunk{}, constants: map[constantKey]int{}}
+}
+
+// Compile a script, a final expression statement becomes its result
+func compile(statements []Stmt) (*Chunk, error) {
+	c := newCompiler()
+
+	for idx, statement := range statements {
+		if x, ok := statement.(*ExprStmt); ok && idx == len(statements)-1 {
+			c.expr(x.expression, x.line)
+			c.emit(x.line, OP_RESULT)
+			continue
+		}
+		c.stmt(statement)
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.chunk, nil
+}
+
+// Compile a function body, falling off the end returns null
+func compileFunction(declaration FnStmt) (*Chunk, error) {
+	c := newCompiler()
+
+	c.stmts(declaration.body)
+	c.emitConstant(declaration.Line(), nullValue())
+	c.emit(declaration.Line(), OP_RETURN)
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.chunk, nil
+}
+
+// ----- Emit helpers
+
+func (c *compiler) emit(line int, op OpCode, operands ...byte) int {
+	return c.chunk.emit(line, op, operands...)
+}
+
+// operand bytes for an index, recording an error when it does not fit
+func (c *compiler) operand(n int) []byte {
+	if n > maxOperand {
+		c.err = errChunkTooLarge
+	}
+	return []byte{byte(n >> 8), byte(n)}
+}
+
+func (c *compiler) emitConstant(line int, value Object) {
+	key := constantKey{kind: value.kind}
+
+	switch value.kind {
+	case kindNumber:
+		key.bits = math.Float64bits(value.num)
+	case kindString:
+		key.text = value.ref.(string)
+	}
+
+	idx, ok := c.constants[key]
+
+	if !ok {
+		idx = len(c.chunk.constants)
+		c.chunk.constants = append(c.chunk.constants, value)
+		c.constants[key] = idx
+	}
+
+	c.emit(line, OP_CONSTANT, c.operand(idx)...)
+}
+
+// emit an instruction whose operand is a token
+func (c *compiler) emitToken(op OpCode, tok Token) {
+	c.chunk.tokens = append(c.chunk.tokens, tok)
+	c.emit(tok.GetLine(), op, c.operand(len(c.chunk.tokens)-1)...)
+}
+
+// emit a forward jump, returns where to patch its offset
+func (c *compiler) emitJump(line int, op OpCode) int {
+	return c.emit(line, op, 0, 0) + 1
+}
+
+// point the jump operand at offset to the next instruction
+func (c *compiler) patchJump(offset int) {
+	distance := len(c.chunk.code) - (offset + 2)
+	copy(c.chunk.code[offset:], c.operand(distance))
+}
+
+// emit a backward jump to start
+func (c *compiler) emitLoop(line int, start int) {
+	c.emit(line, OP_LOOP, c.operand(len(c.chunk.code)+3-start)...)
+}
+
+// emit a variable access, globals are found by name
+func (c *compiler) variable(global, local OpCode, depth, slot int, name Token) {
+	if depth < 0 {
+		c.emitToken(global, name)
+		return
+	}
+
+	c.chunk.locals = append(c.chunk.locals, localRef{depth, slot, name})
+	c.emit(name.GetLine(), local, c.operand(len(c.chunk.locals)-1)...)
+}
+
+// emit a declaration of the top value
+func (c *compiler) define(slot int, name Token) {
+	if slot < 0 {
+		c.emitToken(OP_DEFINE, name)
+		return
+	}
+	c.emit(name.GetLine(), OP_DEFINE_LOCAL, c.operand(slot)...)
+}
+
+// ----- Statements
+
+func (c *compiler) stmts(statements []Stmt) {
+	for _, statement := range statements {
+		c.stmt(statement)
+	}
+}
+
+func (c *compiler) stmt(statement Stmt) {
+	switch s := statement.(type) {
+	case nil:
+	case *ExprStmt:
+		c.expr(s.expression, s.line)
+		c.emit(s.line, OP_POP)
+
+	case *PrintStmt:
+		c.expr(s.expression, s.Line())
+		c.emit(s.Line(), OP_PRINT)
+
+	case *AssertStmt:
+		c.assert(s)
+
+	case *BlockStmt:
+		// resolved blocks without locals share the enclosing scope
+		if s.frame != nil && len(s.frame.names) == 0 {
+			c.stmts(s.statements)
+			return
+		}
+
+		c.chunk.frames = append(c.chunk.frames, s.frame)
+		c.emit(s.line, OP_BEGIN_SCOPE, c.operand(len(c.chunk.frames)-1)...)
+		c.stmts(s.statements)
+		c.emit(s.line, OP_END_SCOPE)
+
+	case *IfStmt:
+		c.expr(s.condition, s.Line())
+		elseJump := c.emitJump(s.Line(), OP_POP_JUMP_IF_FALSE)
+		c.stmt(s.thenBranch)
+
+		if s.elseBranch == nil {
+			c.patchJump(elseJump)
+			return
+		}
+
+		endJump := c.emitJump(s.Line(), OP_JUMP)
+		c.patchJump(elseJump)
+		c.stmt(s.elseBranch)
+		c.patchJump(endJump)
+
+	case *WhileStmt:
+		start := len(c.chunk.code)
+		c.expr(s.condition, s.Line())
+		exitJump := c.emitJump(s.Line(), OP_POP_JUMP_IF_FALSE)
+		c.emitToken(OP_CHECKPOINT, s.keyword)
+		c.stmt(s.body)
+		c.emitLoop(s.Line(), start)
+		c.patchJump(exitJump)
+
+	case *ReturnStmt:
+		if s.tail {
+			c.call(s.value.(*CallExpr), OP_TAIL_CALL)
+			return
+		}
+
+		if s.value != nil {
+			c.expr(s.value, s.Line())
+		} else {
+			c.emitConstant(s.Line(), nullValue())
+		}
+		c.emit(s.Line(), OP_RETURN)
+
+	case *FnStmt:
+		chunk, err := compileFunction(*s)
+
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		c.chunk.functions = append(c.chunk.functions, fnProto{*s, chunk})
+		c.emit(s.Line(), OP_FUNCTION, c.operand(len(c.chunk.functions)-1)...)
+
+	case *VarStmt:
+		if s.initializer != nil {
+			c.expr(s.initializer, s.Line())
+		} else {
+			c.emitConstant(s.Line(), nullValue())
+		}
+		c.define(s.slot, s.name)
+
+	default:
+		c.err = fmt.Errorf("%w: compiler does not handle %T", ErrInternal, statement)
+	}
+}
+
+// Assertions keep the operands of a comparison for the failure message,
+// the message expression only runs when the assertion fails
+func (c *compiler) assert(s *AssertStmt) {
+	info := assertInfo{s.keyword, exprSource(s.condition), false, s.message != nil}
+
+	if b, ok := s.condition.(*BinaryExpr); ok && isComparison(b.operator.GetType()) {
+		info.compare = true
+		c.expr(b.right, s.Line())
+		c.expr(b.left, s.Line())
+		c.emitToken(OP_COMPARE, b.operator)
+	} else {
+		c.expr(s.condition, s.Line())
+	}
+
+	c.chunk.asserts = append(c.chunk.asserts, info)
+	idx := c.operand(len(c.chunk.asserts) - 1)
+
+	passJump := c.emit(s.Line(), OP_ASSERT, idx[0], idx[1], 0, 0) + 3
+
+	if s.message != nil {
+		c.expr(s.message, s.Line())
+	}
+	c.emit(s.Line(), OP_ASSERT_FAIL, idx...)
+	c.patchJump(passJump)
+}
+
+// ----- Expressions
+
+// line is used for instructions without a token of their own
+func (c *compiler) expr(expression Expr, line int) {
+	switch x := expression.(type) {
+	case *Literal:
+		c.emitConstant(line, x.value)
+
+	case *GroupingExpr:
+		c.expr(x.expression, line)
+
+	case *VarExpr:
+		c.variable(OP_GET, OP_GET_LOCAL, x.depth, x.slot, x.name)
+
+	case *AssignExpr:
+		c.expr(x.value, x.name.GetLine())
+		c.variable(OP_SET, OP_SET_LOCAL, x.depth, x.slot, x.name)
+
+	case *UnaryExpr:
+		c.expr(x.right, x.operator.GetLine())
+		c.emitToken(OP_UNARY, x.operator)
+
+	case *BinaryExpr:
+		// the right operand runs first
+		c.expr(x.right, x.operator.GetLine())
+		c.expr(x.left, x.operator.GetLine())
+		c.emitToken(OP_BINARY, x.operator)
+
+	case *LogicalExpr:
+		c.expr(x.left, x.operator.GetLine())
+
+		op := OP_JUMP_IF_FALSE
+		if x.operator.GetType() == OR {
+			op = OP_JUMP_IF_TRUE
+		}
+
+		endJump := c.emitJump(x.operator.GetLine(), op)
+		c.emit(x.operator.GetLine(), OP_POP)
+		c.expr(x.right, x.operator.GetLine())
+		c.patchJump(endJump)
+
+	case *CallExpr:
+		c.call(x, OP_CALL)
+
+	case *GetExpr:
+		c.expr(x.object, x.name.GetLine())
+		c.emitToken(OP_PROPERTY, x.name)
+
+	default:
+		c.err = fmt.Errorf("%w: compiler does not handle %T", ErrInternal, expression)
+	}
+}
+
+// callee and arguments followed by a call instruction
+func (c *compiler) call(x *CallExpr, op OpCode) {
+	c.expr(x.callee, x.paren.GetLine())
+
+	for _, argument := range x.arguments {
+		c.expr(argument, x.paren.GetLine())
+	}
+
+	c.chunk.tokens = append(c.chunk.tokens, x.paren)
+	tok := c.operand(len(c.chunk.tokens) - 1)
+	c.emit(x.paren.GetLine(), op, byte(len(x.arguments)), tok[0], tok[1])
+}
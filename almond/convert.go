@@ -0,0 +1,368 @@
+package almond
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+var (
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	objectType   = reflect.TypeOf(Object{})
+	callableType = reflect.TypeOf((*Callable)(nil)).Elem()
+	functionType = reflect.TypeOf((*Function)(nil))
+)
+
+// Nesting limit when checking Go values, guards against cycles
+const maxConvertDepth = 64
+
+// Convert a script value to its natural Go type:
+// bool, nil, float64, string, *Function or the wrapped host value
+func ToGo(obj Object) any {
+	switch obj.kind {
+	case kindTrue:
+		return true
+	case kindFalse:
+		return false
+	case kindCallable:
+		return NewFunction(obj.ref.(Callable))
+	case kindNumber:
+		return obj.num
+	case kindString, kindHost:
+		return obj.ref
+	}
+	return nil
+}
+
+// Convert a Go value to a script value, slices, maps and structs become host values
+func FromGo(v any) (Object, error) {
+	value := reflect.ValueOf(v)
+
+	err := checkGo(value, 0)
+
+	if err != nil {
+		return Object{}, err
+	}
+	return toObject(value)
+}
+
+// Reject values holding types scripts cannot use, such as channels
+func checkGo(value reflect.Value, depth int) error {
+	if !value.IsValid() {
+		return nil
+	}
+
+	if depth > maxConvertDepth {
+		return errors.New("value nested too deeply")
+	}
+
+	switch value.Kind() {
+	case reflect.Chan, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return fmt.Errorf("unsupported Go type %s", value.Type())
+
+	case reflect.Interface:
+		if !value.IsNil() {
+			return checkGo(value.Elem(), depth+1)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for idx := 0; idx < value.Len(); idx++ {
+			err := checkGo(value.Index(idx), depth+1)
+
+			if err != nil {
+				return fmt.Errorf("index %d: %w", idx, err)
+			}
+		}
+
+	case reflect.Map:
+		iter := value.MapRange()
+
+		for iter.Next() {
+			err := checkGo(iter.Key(), depth+1)
+
+			if err == nil {
+				err = checkGo(iter.Value(), depth+1)
+			}
+
+			if err != nil {
+				return fmt.Errorf("key %v: %w", iter.Key(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// Convert a script value to a Go value of type t
+func fromObject(obj Object, t reflect.Type) (reflect.Value, error) {
+	if t == objectType {
+		return reflect.ValueOf(obj), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		num, ok := obj.num, obj.kind == kindNumber
+
+		if !ok {
+			return reflect.Value{}, typeError(obj, t)
+		}
+		value := reflect.New(t).Elem()
+		value.SetFloat(num)
+		return value, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num, ok := obj.num, obj.kind == kindNumber
+
+		if !ok {
+			return reflect.Value{}, typeError(obj, t)
+		}
+
+		if !fitsInteger(num, t) {
+			return reflect.Value{}, fmt.Errorf("%v does not fit in %s", num, t)
+		}
+
+		value := reflect.New(t).Elem()
+		value.SetInt(int64(num))
+		return value, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		num, ok := obj.num, obj.kind == kindNumber
+
+		if !ok {
+			return reflect.Value{}, typeError(obj, t)
+		}
+
+		if !fitsInteger(num, t) {
+			return reflect.Value{}, fmt.Errorf("%v does not fit in %s", num, t)
+		}
+
+		value := reflect.New(t).Elem()
+		value.SetUint(uint64(num))
+		return value, nil
+
+	case reflect.String:
+		str, ok := obj.ref.(string)
+
+		if !ok {
+			return reflect.Value{}, typeError(obj, t)
+		}
+		return reflect.ValueOf(str).Convert(t), nil
+
+	case reflect.Bool:
+		switch obj.kind {
+		case kindTrue:
+			return reflect.ValueOf(true).Convert(t), nil
+		case kindFalse:
+			return reflect.ValueOf(false).Convert(t), nil
+		}
+		return reflect.Value{}, typeError(obj, t)
+
+	case reflect.Interface:
+		// natural Go value for any and similar interfaces
+		goValue := ToGo(obj)
+
+		if obj.kind == kindCallable && reflect.TypeOf(obj.ref).AssignableTo(t) {
+			goValue = obj.ref
+		}
+
+		if goValue == nil {
+			return reflect.Zero(t), nil
+		}
+
+		value := reflect.ValueOf(goValue)
+
+		if !value.Type().AssignableTo(t) {
+			return reflect.Value{}, typeError(obj, t)
+		}
+		return value, nil
+	}
+
+	if t == functionType {
+		if obj.kind == kindCallable {
+			return reflect.ValueOf(ToGo(obj)), nil
+		}
+		if obj.kind == kindNull {
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, typeError(obj, t)
+	}
+
+	// slices, maps and structs travel as host values
+	if obj.kind == kindNull {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Map, reflect.Pointer:
+			return reflect.Zero(t), nil
+		}
+	}
+
+	if obj.kind == kindHost {
+		return convertGo(reflect.ValueOf(obj.ref), t)
+	}
+	return reflect.Value{}, typeError(obj, t)
+}
+
+// Whole number in the range of integer type t. Checked on the float since
+// converting one outside the range of the integer gives an undefined result.
+func fitsInteger(num float64, t reflect.Type) bool {
+	if num != math.Trunc(num) {
+		return false
+	}
+
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return num >= 0 && num < math.Ldexp(1, t.Bits())
+	}
+	return num >= -math.Ldexp(1, t.Bits()-1) && num < math.Ldexp(1, t.Bits()-1)
+}
+
+// Numeric conversion that keeps the value, Convert wraps or truncates otherwise
+func fitsConversion(value reflect.Value, t reflect.Type) bool {
+	target := reflect.New(t).Elem()
+
+	switch {
+	case value.CanFloat() && (target.CanInt() || target.CanUint()):
+		return fitsInteger(value.Float(), t)
+	case value.CanInt() && target.CanInt():
+		return !target.OverflowInt(value.Int())
+	case value.CanInt() && target.CanUint():
+		return value.Int() >= 0 && !target.OverflowUint(uint64(value.Int()))
+	case value.CanUint() && target.CanInt():
+		return value.Uint() <= math.MaxInt64 && !target.OverflowInt(int64(value.Uint()))
+	case value.CanUint() && target.CanUint():
+		return !target.OverflowUint(value.Uint())
+	}
+	return true
+}
+
+// Convert between Go values, element by element for slices and maps
+func convertGo(value reflect.Value, t reflect.Type) (reflect.Value, error) {
+	// unwrap values stored behind interfaces
+	for value.Kind() == reflect.Interface && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	if value.Type().AssignableTo(t) {
+		return value, nil
+	}
+
+	// values such as script objects inside a []any
+	if value.Type() == objectType {
+		return fromObject(value.Interface().(Object), t)
+	}
+
+	switch {
+	case value.Kind() == reflect.Slice && t.Kind() == reflect.Slice:
+		out := reflect.MakeSlice(t, value.Len(), value.Len())
+
+		for idx := 0; idx < value.Len(); idx++ {
+			elem, err := convertGo(value.Index(idx), t.Elem())
+
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("index %d: %w", idx, err)
+			}
+			out.Index(idx).Set(elem)
+		}
+		return out, nil
+
+	case value.Kind() == reflect.Map && t.Kind() == reflect.Map:
+		out := reflect.MakeMapWithSize(t, value.Len())
+		iter := value.MapRange()
+
+		for iter.Next() {
+			key, err := convertGo(iter.Key(), t.Key())
+
+			if err != nil {
+				return reflect.Value{}, err
+			}
+
+			elem, err := convertGo(iter.Value(), t.Elem())
+
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("key %v: %w", iter.Key(), err)
+			}
+			out.SetMapIndex(key, elem)
+		}
+		return out, nil
+
+	case value.CanConvert(t) && value.Kind() != reflect.String && t.Kind() != reflect.String:
+		if !fitsConversion(value, t) {
+			return reflect.Value{}, fmt.Errorf("%v does not fit in %s", value, t)
+		}
+		return value.Convert(t), nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", value.Type(), t)
+}
+
+// Convert a Go value to a script value
+func toObject(value reflect.Value) (Object, error) {
+	if !value.IsValid() {
+		return nullValue(), nil
+	}
+
+	if value.Type() == objectType {
+		return value.Interface().(Object), nil
+	}
+
+	if value.Type() == functionType && !value.IsNil() {
+		return callableValue(value.Interface().(*Function).fn), nil
+	}
+
+	if value.Type().Implements(callableType) && !isNil(value) {
+		return callableValue(value.Interface().(Callable)), nil
+	}
+
+	switch value.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return numberValue(value.Float()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return numberValue(float64(value.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return numberValue(float64(value.Uint())), nil
+	case reflect.String:
+		return stringValue(value.String()), nil
+	case reflect.Bool:
+		if value.Bool() {
+			return boolValue(true), nil
+		}
+		return boolValue(false), nil
+	case reflect.Interface:
+		if value.IsNil() {
+			return nullValue(), nil
+		}
+		return toObject(value.Elem())
+	case reflect.Func:
+		if value.IsNil() {
+			return nullValue(), nil
+		}
+		fn, err := NewNativeFunc(value.Type().String(), value.Interface())
+
+		if err != nil {
+			return Object{}, err
+		}
+		return callableValue(fn), nil
+	case reflect.Slice, reflect.Map, reflect.Pointer:
+		if value.IsNil() {
+			return nullValue(), nil
+		}
+		return hostValue(value.Interface()), nil
+	case reflect.Struct, reflect.Array:
+		return hostValue(value.Interface()), nil
+	}
+
+	return Object{}, fmt.Errorf("unsupported Go type %s", value.Type())
+}
+
+// Nil check that is safe for every kind
+func isNil(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Pointer, reflect.Slice:
+		return value.IsNil()
+	}
+	return false
+}
+
+// Helper for mismatched argument types
+func typeError(obj Object, t reflect.Type) error {
+	return errors.New("expected " + t.String() + ", got " + obj.GetKindStr())
+}
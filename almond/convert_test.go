@@ -0,0 +1,203 @@
+package almond
+
+import (
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromGo(t *testing.T) {
+	var nilPointer *hostRecord
+	var nilMap map[string]int
+	var nilSlice []int
+
+	cases := []struct {
+		name string
+		in   any
+		want string
+	}{
+		{"nil", nil, "NULL"},
+		{"int", 42, "NUMBER 42"},
+		{"uint8", uint8(7), "NUMBER 7"},
+		{"float32", float32(1.5), "NUMBER 1.5"},
+		{"string", "hi", "STRING hi"},
+		{"bool", true, "TRUE"},
+		{"nil pointer", nilPointer, "NULL"},
+		{"nil map", nilMap, "NULL"},
+		{"nil slice", nilSlice, "NULL"},
+		{"nil func", (func())(nil), "NULL"},
+		{"nil interface", []any{nil}[0], "NULL"},
+		{"value", stringValue("kept"), "STRING kept"},
+		{"slice", []int{1, 2}, "HOST [1 2]"},
+		{"map", map[string]int{"a": 1}, "HOST map[a:1]"},
+		{"struct", hostRecord{"x"}, "HOST {x}"},
+		{"func", func() int { return 1 }, "CALLABLE <native fn func() int>"},
+	}
+
+	for _, c := range cases {
+		obj, err := FromGo(c.in)
+
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		got := obj.GetKindStr()
+		if obj.kind != kindNull && obj.kind != kindTrue && obj.kind != kindFalse {
+			got += " " + obj.String()
+		}
+
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// Types scripts cannot hold are rejected, also when nested
+func TestFromGoRejectsUnsupported(t *testing.T) {
+	cases := map[string]any{
+		"chan":          make(chan int),
+		"complex":       complex(1, 2),
+		"nested in map": map[string][]any{"k": {1, make(chan int)}},
+	}
+
+	for name, value := range cases {
+		if _, err := FromGo(value); err == nil || !strings.Contains(err.Error(), "unsupported Go type") {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+
+	if _, err := FromGo(map[string][]any{"k": {1, make(chan int)}}); err.Error() != "key k: index 1: unsupported Go type chan int" {
+		t.Errorf("nested error: got %v", err)
+	}
+}
+
+func TestToGo(t *testing.T) {
+	record := &hostRecord{"x"}
+
+	cases := []struct {
+		in   Object
+		want any
+	}{
+		{nullValue(), nil},
+		{boolValue(true), true},
+		{boolValue(false), false},
+		{numberValue(2.5), 2.5},
+		{stringValue("s"), "s"},
+		{hostValue(record), record},
+	}
+
+	for _, c := range cases {
+		if got := ToGo(c.in); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.in.String(), got, c.want)
+		}
+	}
+
+	fn, ok := ToGo(callableValue(NewNativeClock())).(*Function)
+
+	if !ok || fn.Arity() != 0 {
+		t.Errorf("callable: got %v", fn)
+	}
+}
+
+// Numbers only convert to integer types that hold them exactly
+func TestIntegerConversions(t *testing.T) {
+	int8Type := reflect.TypeOf(int8(0))
+	int64Type := reflect.TypeOf(int64(0))
+	uint64Type := reflect.TypeOf(uint64(0))
+
+	cases := []struct {
+		num  float64
+		t    reflect.Type
+		fits bool
+	}{
+		{127, int8Type, true},
+		{-128, int8Type, true},
+		{128, int8Type, false},
+		{-129, int8Type, false},
+		{1.5, int8Type, false},
+		{-9223372036854775808, int64Type, true},
+		{9223372036854775808, int64Type, false},
+		{1e19, int64Type, false},
+		{-1e19, int64Type, false},
+		{math.Inf(1), int64Type, false},
+		{math.NaN(), int64Type, false},
+		{18446744073709549568, uint64Type, true},
+		{18446744073709551616, uint64Type, false},
+		{-1, uint64Type, false},
+	}
+
+	for _, c := range cases {
+		value, err := fromObject(numberValue(c.num), c.t)
+
+		if c.fits && (err != nil || value.Convert(reflect.TypeOf(float64(0))).Float() != c.num) {
+			t.Errorf("%v as %s: got %v, %v", c.num, c.t, value, err)
+		}
+
+		if !c.fits && (err == nil || !strings.Contains(err.Error(), "does not fit in "+c.t.String())) {
+			t.Errorf("%v as %s: got %v, %v", c.num, c.t, value, err)
+		}
+	}
+}
+
+// Host slices and maps convert element by element into the parameter type
+func TestNestedConversions(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+
+	engine.RegisterFunc("sum", func(rows [][]float64) float64 {
+		total := 0.0
+		for _, row := range rows {
+			for _, n := range row {
+				total += n
+			}
+		}
+		return total
+	})
+	engine.RegisterFunc("count", func(groups map[string][]int8) int {
+		return len(groups["a"]) + len(groups["b"])
+	})
+	engine.RegisterFunc("size", func(m map[string]int, s []int, p *hostRecord) int {
+		return len(m) + len(s)
+	})
+
+	engine.SetGlobal("rows", hostValue([][]int{{1, 2}, {3}}))
+	engine.SetGlobal("mixed", hostValue([]any{[]any{1, 2.5}, []any{numberValue(3)}}))
+	engine.SetGlobal("groups", hostValue(map[string][]int{"a": {1, 2}, "b": {3}}))
+	engine.SetGlobal("wide", hostValue(map[string][]int{"a": {1, 300}}))
+	engine.SetGlobal("fraction", hostValue([][]any{{0.5}}))
+
+	values := map[string]string{
+		`sum(rows);`:              "6",
+		`sum(mixed);`:             "6.5",
+		`count(groups);`:          "3",
+		`size(null, null, null);`: "0",
+	}
+
+	for src, want := range values {
+		value, err := engine.Eval(src)
+
+		if err != nil || value.String() != want {
+			t.Errorf("%s: got %s, %v", src, value.String(), err)
+		}
+	}
+
+	failures := map[string]string{
+		`count(wide);`:     "key a: index 1: 300 does not fit in int8",
+		`count(fraction);`: "cannot use [][]interface {} as map[string][]int8",
+		`sum("rows");`:     "expected [][]float64, got STRING",
+	}
+
+	for src, want := range failures {
+		if _, err := engine.Eval(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+
+	engine.RegisterFunc("first", func(xs []int) int { return xs[0] })
+
+	if _, err := engine.Eval(`first(fraction);`); err == nil || !strings.Contains(err.Error(), "index 0") {
+		t.Errorf("fraction: got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package almond
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Script value handed to host code
+type Value = Object
+
+// Settings for an embedded interpreter, zero values use the defaults
+type Options struct {
+	// Destination of print statements, defaults to os.Stdout
+	Stdout io.Writer
+	// Flush print output after every line, otherwise it is written when the
+	// buffer fills and when an evaluation ends
+	LineBuffered bool
+	// Destination of parse and runtime errors, defaults to os.Stderr
+	Stderr io.Writer
+
+	// Nested call limit, 0 uses DefaultMaxCallDepth and negative disables it
+	MaxCallDepth int
+	// Loop iteration and call budget per evaluation, 0 disables it
+	MaxSteps int
+	// Wall-clock limit per evaluation, 0 disables it
+	Timeout time.Duration
+	// Approximate limit in bytes on strings held by variables, whether made by
+	// scripts, natives or the host. 0 disables it
+	MaxMemory int64
+
+	// Natives scripts may use, nil uses DefaultCapabilities
+	Capabilities *Capabilities
+
+	// Instrumentation callbacks, nil disables them.
+	// Hooked code always runs on the tree-walker.
+	Hooks Hooks
+
+	// Run on the tree-walking interpreter instead of the bytecode vm
+	TreeWalk bool
+	// Run scripts as written, without constant folding or dead code removal
+	NoOptimize bool
+
+	// Skip the embedded prelude
+	NoPrelude bool
+	// Prelude to load instead of the embedded one
+	Prelude *Prelude
+}
+
+// Interpreter for use from Go programs
+type Engine struct {
+	inter  *Interpreter
+	stderr io.Writer
+}
+
+// Ctor, fails only when the prelude raises an error
+func NewEngine(opts Options) (*Engine, error) {
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+
+	caps := DefaultCapabilities
+	if opts.Capabilities != nil {
+		caps = *opts.Capabilities
+	}
+
+	prelude := opts.Prelude
+	switch {
+	case opts.NoPrelude:
+		prelude = nil
+	case prelude == nil:
+		embedded, err := DefaultPrelude()
+
+		if err != nil {
+			return nil, err
+		}
+		prelude = embedded
+	}
+
+	// without a prelude this cannot fail, it is loaded below
+	inter, _ := NewInterpreterWith(caps, nil)
+	inter.SetOutput(opts.Stdout)
+	inter.SetLineBuffered(opts.LineBuffered)
+	inter.SetStepLimit(opts.MaxSteps)
+	inter.SetTimeout(opts.Timeout)
+	inter.SetMemoryLimit(opts.MaxMemory)
+	inter.SetTreeWalk(opts.TreeWalk)
+	inter.SetOptimize(!opts.NoOptimize)
+
+	switch {
+	case opts.MaxCallDepth > 0:
+		inter.SetMaxCallDepth(opts.MaxCallDepth)
+	case opts.MaxCallDepth < 0:
+		inter.SetMaxCallDepth(0)
+	}
+
+	// prelude output and limits follow the options
+	if prelude != nil {
+		err := prelude.load(inter)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// hooks only see the host's own scripts
+	inter.SetHooks(opts.Hooks)
+
+	return &Engine{inter, opts.Stderr}, nil
+}
+
+// Run source code and return the value of its last expression statement
+func (en *Engine) Eval(src string) (Value, error) {
+	return en.EvalContext(context.Background(), src)
+}
+
+// Run source code until done or ctx is cancelled
+func (en *Engine) EvalContext(ctx context.Context, src string) (Value, error) {
+	tokenizer := NewTokenizer(src)
+	tokenizer.SetOutput(en.stderr)
+	parser := NewStreamParser(tokenizer)
+	parser.SetOutput(en.stderr)
+	statements := parser.Parse()
+
+	faults := append(tokenizer.Faults(), parser.Faults()...)
+
+	if len(faults) > 0 {
+		return Object{}, errors.Join(faults...)
+	}
+
+	value, err := en.inter.evaluate(ctx, statements)
+
+	if err != nil {
+		fmt.Fprintln(en.stderr, err)
+		return Object{}, err
+	}
+	return value, nil
+}
+
+// Run a source file
+func (en *Engine) EvalFile(filename string) (Value, error) {
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		return Object{}, err
+	}
+	return en.Eval(string(data))
+}
+
+// Define or replace a global variable, strings count against the memory limit
+func (en *Engine) SetGlobal(name string, value Value) error {
+	err := en.inter.adopt(value)
+
+	if err != nil {
+		return err
+	}
+
+	en.inter.env.Define(name, value)
+	return nil
+}
+
+// Look up a global variable or native
+func (en *Engine) GetGlobal(name string) (Value, bool) {
+	return en.inter.env.lookup(name)
+}
+
+// Expose a Go function to scripts, a returned error is raised as a runtime error
+func (en *Engine) RegisterFunc(name string, fn any) error {
+	native, err := NewNativeFunc(name, fn)
+
+	if err != nil {
+		return err
+	}
+
+	en.inter.env.Define(name, callableValue(native))
+	return nil
+}
+
+// Approximate bytes currently held by variables
+func (en *Engine) MemoryUsage() int64 {
+	return en.inter.MemoryUsage()
+}
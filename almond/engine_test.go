@@ -0,0 +1,366 @@
+package almond
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Engines running in parallel must not see each other's state or errors
+func TestEnginesAreIsolated(t *testing.T) {
+	const engines = 48
+	var wg sync.WaitGroup
+
+	for idx := 0; idx < engines; idx++ {
+		wg.Add(1)
+
+		go func(idx int) {
+			defer wg.Done()
+
+			var stdout, stderr bytes.Buffer
+			engine, err := NewEngine(Options{Stdout: &stdout, Stderr: &stderr})
+
+			if err != nil {
+				t.Errorf("engine %d: %v", idx, err)
+				return
+			}
+
+			// every third engine runs a script with a syntax error
+			if idx%3 == 0 {
+				if _, err := engine.Eval("var = ;"); err == nil {
+					t.Errorf("engine %d: expected a parse error", idx)
+				}
+				return
+			}
+
+			engine.SetGlobal("id", numberValue(float64(idx)))
+			value, err := engine.Eval(`
+				fn fib(n) {
+					if (n <= 1) return n;
+					return fib(n - 2) + fib(n - 1);
+				}
+				var total = 0;
+				for (var i = 0; i < 10; i = i + 1) {
+					total = total + fib(i);
+				}
+				print id;
+				total + id;`)
+
+			if err != nil {
+				t.Errorf("engine %d: %v (stderr %q)", idx, err, stderr.String())
+				return
+			}
+
+			if got, want := value.String(), fmt.Sprint(88+idx); got != want {
+				t.Errorf("engine %d: got %s, want %s", idx, got, want)
+			}
+			if got := strings.TrimSpace(stdout.String()); got != fmt.Sprint(idx) {
+				t.Errorf("engine %d: printed %q", idx, got)
+			}
+		}(idx)
+	}
+
+	wg.Wait()
+}
+
+// Parsed statements may be shared by interpreters on different goroutines
+func TestSharedStatements(t *testing.T) {
+	statements := NewParser(NewTokenizer(`
+		fn count(n) {
+			var i = 0;
+			while (i < n) i = i + 1;
+			return i;
+		}
+		var result = count(500);`).Tokenize()).Parse()
+
+	var wg sync.WaitGroup
+
+	for idx := 0; idx < 16; idx++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			inter, _ := NewInterpreter()
+
+			if err := inter.Interpret(statements); err != nil {
+				t.Error(err)
+				return
+			}
+
+			if value, _ := inter.env.lookup("result"); value.String() != "500" {
+				t.Errorf("got %s, want 500", value.String())
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// Counts the writes reaching the destination of print output
+type writeCounter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+// Print output is written once an evaluation ends, or per line when line buffered
+func TestPrintIsBuffered(t *testing.T) {
+	src := `for (var i = 0; i < 100; i = i + 1) print i; print missing;`
+
+	for _, lineBuffered := range []bool{false, true} {
+		var stdout writeCounter
+		var stderr bytes.Buffer
+		engine, _ := NewEngine(Options{Stdout: &stdout, Stderr: &stderr, LineBuffered: lineBuffered})
+
+		if _, err := engine.Eval(src); err == nil {
+			t.Fatalf("line buffered %v: expected an error", lineBuffered)
+		}
+
+		// output before the error is not lost
+		if lines := strings.Count(stdout.String(), "\n"); lines != 100 {
+			t.Errorf("line buffered %v: got %d lines", lineBuffered, lines)
+		}
+
+		if want := map[bool]int{false: 1, true: 100}[lineBuffered]; stdout.writes != want {
+			t.Errorf("line buffered %v: got %d writes, want %d", lineBuffered, stdout.writes, want)
+		}
+	}
+}
+
+// Globals stay held across evaluations and count against the limit
+func TestMemoryLimitSpansEvaluations(t *testing.T) {
+	const limit = 1 << 16
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, MaxMemory: limit})
+
+	var err error
+	evals := 0
+
+	for ; evals < 200 && err == nil; evals++ {
+		// a 16KB global each time
+		_, err = engine.Eval(fmt.Sprintf(`var g%d = "x"; for (var i = 0; i < 14; i = i + 1) g%d = g%d + g%d;`, evals, evals, evals, evals))
+
+		if err == nil && engine.MemoryUsage() < int64(evals+1)<<14 {
+			t.Fatalf("eval %d: usage %d does not include earlier globals", evals, engine.MemoryUsage())
+		}
+	}
+
+	// three globals are held, the fourth does not fit next to them
+	if !errors.Is(err, ErrMemoryLimit) || evals != 4 {
+		t.Errorf("got %v after %d evaluations", err, evals)
+	}
+
+	if usage := engine.MemoryUsage(); usage > limit {
+		t.Errorf("usage %d is over the limit", usage)
+	}
+}
+
+// Values that are reassigned or go out of scope are given back
+func TestMemoryLimitCountsLiveValues(t *testing.T) {
+	const limit = 1000
+	big := strings.Repeat("x", 300)
+
+	scripts := []string{
+		`var s = ""; for (var i = 0; i < 20000; i = i + 1) s = "a" + "b";`,
+		`var t = ""; for (var i = 0; i < 2000; i = i + 1) t = record.Text;`,
+		`for (var i = 0; i < 2000; i = i + 1) { var u = record.Text + "!"; }`,
+		`fn local() { var v = record.Text + "?"; return 1; } for (var i = 0; i < 2000; i = i + 1) local();`,
+		`fn early() { if (true) { var w = record.Text + "."; return w; } } for (var i = 0; i < 2000; i = i + 1) early();`,
+		`fn param(p) { return p; } for (var i = 0; i < 2000; i = i + 1) param(record.Text);`,
+	}
+
+	for _, treeWalk := range []bool{false, true} {
+		engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, MaxMemory: limit, TreeWalk: treeWalk})
+		engine.SetGlobal("record", hostValue(&hostRecord{big}))
+
+		for _, src := range scripts {
+			if _, err := engine.Eval(src); err != nil {
+				t.Errorf("tree %v, %s: %v", treeWalk, src, err)
+			}
+		}
+
+		// the host's engine is reused, only the last values are held
+		for idx := 0; idx < 50; idx++ {
+			if _, err := engine.Eval(`s = "x" + "y"; t = record.Text;`); err != nil {
+				t.Fatalf("tree %v, eval %d: %v", treeWalk, idx, err)
+			}
+		}
+
+		if usage := engine.MemoryUsage(); usage != 2+int64(len(big))+2*valueHeaderSize {
+			t.Errorf("tree %v: usage %d", treeWalk, usage)
+		}
+
+		engine.Eval(`s = null; t = 1;`)
+
+		if usage := engine.MemoryUsage(); usage != 0 {
+			t.Errorf("tree %v: usage %d after clearing", treeWalk, usage)
+		}
+
+		// doubling still stops at the limit
+		if _, err := engine.Eval(`var d = "x"; while (true) d = d + d;`); !errors.Is(err, ErrMemoryLimit) {
+			t.Errorf("tree %v: doubling got %v", treeWalk, err)
+		}
+	}
+}
+
+// Closures keep the scope they were made in, so its values stay held
+func TestMemoryLimitKeepsCapturedScopes(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, MaxMemory: 1 << 16})
+
+	_, err := engine.Eval(`
+		fn keep() { var kept = "abc" + "def"; fn get() { return kept; } return get; }
+		var getter = keep();`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage := engine.MemoryUsage(); usage != 6+valueHeaderSize {
+		t.Errorf("usage %d", usage)
+	}
+}
+
+type hostRecord struct {
+	Text string
+}
+
+// Strings the host hands to scripts count against the limit
+func TestMemoryLimitCountsHostValues(t *testing.T) {
+	big := strings.Repeat("x", 2000)
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, MaxMemory: 1000})
+
+	if err := engine.SetGlobal("big", stringValue(big)); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("SetGlobal: got %v", err)
+	}
+
+	if _, ok := engine.GetGlobal("big"); ok {
+		t.Error("SetGlobal defined a value over the limit")
+	}
+
+	engine.RegisterFunc("blob", func() string { return big })
+	engine.SetGlobal("record", hostValue(&hostRecord{big}))
+
+	for _, src := range []string{`var b = blob();`, `var t = record.Text;`} {
+		if _, err := engine.Eval(src); !errors.Is(err, ErrMemoryLimit) {
+			t.Errorf("%s: got %v", src, err)
+		}
+	}
+
+	engine.Eval(`fn id(x) { return x; }`)
+
+	if _, err := engine.Call("id", big); !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("Call: got %v", err)
+	}
+
+	if usage := engine.MemoryUsage(); usage > 1000 {
+		t.Errorf("usage %d is over the limit", usage)
+	}
+}
+
+// Eval hands back the last expression statement and keeps globals between calls
+func TestEvalValues(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+
+	cases := []struct {
+		src     string
+		kind    TokenType
+		literal any
+		text    string
+	}{
+		{`var x = 40;`, NULL, nil, "NULL"},
+		{`x + 2;`, NUMBER, 42.0, "42"},
+		{`"al" + "mond";`, STRING, "almond", "almond"},
+		{`x > 1;`, TRUE, nil, "TRUE"},
+		{`1; "two"; x == 1;`, FALSE, nil, "FALSE"},
+		{`fn f() {} print 1;`, NULL, nil, "NULL"},
+	}
+
+	for _, c := range cases {
+		value, err := engine.Eval(c.src)
+
+		if err != nil {
+			t.Fatalf("%s: %v", c.src, err)
+		}
+
+		if value.GetKind() != c.kind || value.GetLiteral() != c.literal || value.String() != c.text {
+			t.Errorf("%s: got %s %v %q", c.src, value.GetKindStr(), value.GetLiteral(), value.String())
+		}
+	}
+
+	if value, _ := engine.Eval(`x;`); !value.Bool() {
+		t.Error("40 should be truthy")
+	}
+}
+
+// Print output and errors go to the configured writers, errors are also returned
+func TestEvalOutputAndErrors(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	engine, _ := NewEngine(Options{Stdout: &stdout, Stderr: &stderr})
+
+	engine.Eval(`print "hello";`)
+
+	if stdout.String() != "hello\n" || stderr.Len() != 0 {
+		t.Errorf("print: got stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	_, err := engine.Eval("print 1;\nvar = 2;")
+
+	var parseFault *ParseFault
+	if !errors.As(err, &parseFault) || parseFault.Line != 2 {
+		t.Errorf("parse error: got %v", err)
+	}
+
+	// nothing runs when the source does not parse
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "[line 2] Error at '='") {
+		t.Errorf("parse error: got stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+
+	stderr.Reset()
+	_, err = engine.Eval("print 1;\nprint missing;")
+
+	var runtimeFault *RuntimeFault
+	if !errors.As(err, &runtimeFault) || runtimeFault.Line != 2 {
+		t.Errorf("runtime error: got %v", err)
+	}
+
+	if stdout.String() != "1\n" || !strings.Contains(stderr.String(), "Undefined variable 'missing'") {
+		t.Errorf("runtime error: got stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestEngineGlobalsAndFiles(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+
+	if _, ok := engine.GetGlobal("name"); ok {
+		t.Error("found an undefined global")
+	}
+
+	engine.SetGlobal("name", stringValue("world"))
+	filename := filepath.Join(t.TempDir(), "greet.al")
+	os.WriteFile(filename, []byte(`var greeting = "hello " + name; greeting;`), 0o644)
+
+	value, err := engine.EvalFile(filename)
+
+	if err != nil || value.String() != "hello world" {
+		t.Errorf("EvalFile: got %s, %v", value.String(), err)
+	}
+
+	if value, ok := engine.GetGlobal("greeting"); !ok || value.String() != "hello world" {
+		t.Errorf("GetGlobal: got %s, %v", value.String(), ok)
+	}
+
+	if _, err := engine.EvalFile(filename + ".missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v", err)
+	}
+}
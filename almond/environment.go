@@ -1,27 +1,29 @@
 package almond
 
-import (
-	"errors"
-)
-
+// Scope of variables. Globals and unresolved code keep names in lut,
+// resolved functions and blocks keep locals in slots named by frame.
 type Environment struct {
 	enclosing *Environment
 	lut       map[string]Object
+	slots     []Object
+	frame     *frameInfo
+	inter     *Interpreter
+	// kept alive by a closure, so leaving it gives nothing back
+	captured bool
 }
 
-// Ctor
+// Ctor with the default natives
 func NewEnv() *Environment {
+	return NewSandboxEnv(DefaultCapabilities)
+}
+
+// Ctor with the natives allowed by caps
+func NewSandboxEnv(caps Capabilities) *Environment {
 	// Create global functions
 	lut := map[string]Object{}
-	env := Environment{nil, lut}
+	env := Environment{enclosing: nil, lut: lut}
 
-	// Global clock
-	clockObj := NewObject(CALLABLE, NewNativeClock())
-	env.Define("clock", *clockObj)
-
-	// Global clock
-	sleepObj := NewObject(CALLABLE, NewNativeSleep())
-	env.Define("sleepMS", *sleepObj)
+	caps.install(&env)
 
 	return NewEnclosedEnv(&env)
 }
@@ -29,47 +31,183 @@ func NewEnv() *Environment {
 // Ctor with existing env
 func NewEnclosedEnv(e *Environment) *Environment {
 	lut := map[string]Object{}
-	return &Environment{e, lut}
+	return &Environment{enclosing: e, lut: lut, inter: e.inter}
+}
+
+// Ctor for the slots of a resolved scope
+func newFrame(e *Environment, frame *frameInfo) *Environment {
+	return &Environment{enclosing: e, slots: make([]Object, len(frame.names)), frame: frame, inter: e.inter}
+}
+
+// Mark env and the scopes around it as kept by a closure
+func (e *Environment) capture() {
+	for env := e; env != nil && !env.captured; env = env.enclosing {
+		env.captured = true
+	}
+}
+
+// Leave a scope made by enterBlock
+func (e *Environment) leaveBlock(block *Environment) {
+	if block != e {
+		e.inter.release(block)
+	}
+}
+
+// store a value in a slot
+func (e *Environment) setSlot(slot int, value Object) {
+	e.inter.store(e.slots[slot], value)
+	e.slots[slot] = value
+}
+
+// Scope for a block, resolved blocks without locals reuse e
+func (e *Environment) enterBlock(frame *frameInfo) *Environment {
+	switch {
+	case frame == nil:
+		return NewEnclosedEnv(e)
+	case len(frame.names) == 0:
+		return e
+	}
+	return newFrame(e, frame)
+}
+
+// Slots hold the zero Object until their declaration runs
+func unset(value Object) bool {
+	return value.kind == kindUnset
 }
 
 // ---- Functions
 
 // store variables or functions
 func (e *Environment) Define(name string, value Object) {
+	if e.frame != nil {
+		if slot := e.frame.slot(name); slot >= 0 {
+			e.setSlot(slot, value)
+			return
+		}
+	}
+
+	if e.lut == nil {
+		e.lut = map[string]Object{}
+	}
+	e.inter.store(e.lut[name], value)
 	e.lut[name] = value
 }
 
+// store a declaration, resolved declarations have a slot
+func (e *Environment) defineAt(slot int, name string, value Object) {
+	if slot >= 0 {
+		e.setSlot(slot, value)
+		return
+	}
+	e.Define(name, value)
+}
+
 // retrieve variables or functions
 func (e *Environment) Get(tok Token) (Object, error) {
 	name := tok.GetLexeme()
-	value, ok := e.lut[name]
+	value, ok := e.lookup(name)
 
 	if ok {
 		return value, nil
 	}
 
-	if e.enclosing != nil {
-		return e.enclosing.Get(tok)
+	return Object{}, RuntimeError("Undefined variable '"+name+"'.", tok)
+}
+
+// retrieve a resolved variable, depth -1 or a slot not declared yet looks the name up
+func (e *Environment) getAt(depth, slot int, tok Token) (Object, error) {
+	if depth >= 0 {
+		value := e.ancestor(depth).slots[slot]
+
+		if !unset(value) {
+			return value, nil
+		}
+	}
+	return e.Get(tok)
+}
+
+// scope depth levels out
+func (e *Environment) ancestor(depth int) *Environment {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.enclosing
+	}
+	return env
+}
+
+// find where a name is stored, the slot is -1 for names in a lut
+func (e *Environment) find(name string) (*Environment, int) {
+	for env := e; env != nil; env = env.enclosing {
+		if env.frame != nil {
+			if slot := env.frame.slot(name); slot >= 0 && !unset(env.slots[slot]) {
+				return env, slot
+			}
+		}
+
+		if _, ok := env.lut[name]; ok {
+			return env, -1
+		}
 	}
+	return nil, -1
+}
+
+// retrieve a value by name without reporting an error
+func (e *Environment) lookup(name string) (Object, bool) {
+	env, slot := e.find(name)
 
-	RuntimeError("Undefined variable '"+name+"'.", tok)
-	return Object{}, errors.New("undefined variable error")
+	switch {
+	case env == nil:
+		return Object{}, false
+	case slot >= 0:
+		return env.slots[slot], true
+	}
+	return env.lut[name], true
 }
 
 // update variables or function
 func (e *Environment) Assign(tok Token, value Object) error {
 	name := tok.GetLexeme()
-	_, ok := e.lut[name]
+	env, slot := e.find(name)
+
+	switch {
+	case env == nil:
+		return RuntimeError("Undefined variable '"+name+"'.", tok)
+	case slot >= 0:
+		env.setSlot(slot, value)
+	default:
+		e.inter.store(env.lut[name], value)
+		env.lut[name] = value
+	}
+	return nil
+}
 
-	if ok {
-		e.lut[name] = value
-		return nil
+// update a resolved variable, depth -1 or a slot not declared yet looks the name up
+func (e *Environment) assignAt(depth, slot int, tok Token, value Object) error {
+	if depth >= 0 {
+		env := e.ancestor(depth)
+
+		if !unset(env.slots[slot]) {
+			env.setSlot(slot, value)
+			return nil
+		}
 	}
+	return e.Assign(tok, value)
+}
 
-	if e.enclosing != nil {
-		return e.enclosing.Assign(tok, value)
+// names with a value in this scope
+func (e *Environment) names() []string {
+	names := []string{}
+
+	if e.frame != nil {
+		for slot, name := range e.frame.names {
+			if !unset(e.slots[slot]) && e.frame.slot(name) == slot {
+				names = append(names, name)
+			}
+		}
 	}
 
-	RuntimeError("Undefined variable '"+name+"'.", tok)
-	return errors.New("undefined variable error")
+	for name := range e.lut {
+		names = append(names, name)
+	}
+	return names
 }
@@ -1,9 +1,7 @@
 package almond
 
 import (
-	"errors"
 	"fmt"
-	"os"
 )
 
 type Expr interface {
@@ -20,11 +18,11 @@ func NewLiteral(t TokenType) *Literal {
 }
 
 func NewNumber(f float64) *Literal {
-	return &Literal{*NewObject(NUMBER, f)}
+	return &Literal{numberValue(f)}
 }
 
 func NewString(s string) *Literal {
-	return &Literal{*NewObject(STRING, s)}
+	return &Literal{stringValue(s)}
 }
 func (l Literal) Evaluate(e *Environment) (Object, error) {
 	return l.value, nil
@@ -47,31 +45,29 @@ func (u UnaryExpr) Evaluate(e *Environment) (Object, error) {
 		return right, err
 	}
 
-	switch u.operator.GetType() {
+	return unaryOp(u.operator, right)
+}
+
+// Apply a unary operator to an already evaluated operand
+func unaryOp(operator Token, right Object) (Object, error) {
+	switch operator.GetType() {
 	case BANG:
 		// if the value is true -> return false
 		if right.Bool() {
-			return *NewObject(FALSE, nil), nil
+			return boolValue(false), nil
 		} else {
 			// true -> false
-			return *NewObject(TRUE, nil), nil
+			return boolValue(true), nil
 		}
 	case MINUS:
 		// negate number
-		if right.GetKind() == NUMBER {
-			val, ok := right.GetLiteral().(float64)
-
-			if !ok {
-				fmt.Println("Implementation Error: Failed to parse float form number in expr -> unary -> Evaluate")
-				os.Exit(6)
-			}
-			return *NewObject(NUMBER, -1*val), nil
+		if right.kind == kindNumber {
+			return numberValue(-1 * right.num), nil
 		}
 	}
 
 	// Report error
-	RuntimeError("Eval Error: illegal unary operator", u.operator)
-	return Object{}, errors.New("illegal unary operator")
+	return Object{}, RuntimeError("Eval Error: illegal unary operator", operator)
 }
 
 // BINARY EXPRESSION
@@ -99,85 +95,85 @@ func (b BinaryExpr) Evaluate(e *Environment) (Object, error) {
 		return left, err
 	}
 
+	return binaryOp(e, b.operator, left, right)
+}
+
+// Apply a binary operator to already evaluated operands
+func binaryOp(e *Environment, operator Token, left, right Object) (Object, error) {
 	// check equality
-	if b.operator.GetType() == EQUALS {
+	if operator.GetType() == EQUALS {
 		if left.Equal(&right) {
-			return *NewObject(TRUE, nil), nil
+			return boolValue(true), nil
 		}
-		return *NewObject(FALSE, nil), nil
+		return boolValue(false), nil
 	}
-	if b.operator.GetType() == NOT_EQUALS {
+	if operator.GetType() == NOT_EQUALS {
 		if !left.Equal(&right) {
-			return *NewObject(TRUE, nil), nil
+			return boolValue(true), nil
 		}
-		return *NewObject(FALSE, nil), nil
+		return boolValue(false), nil
 	}
 
 	// Report type missmatch for non equality tests
-	if right.GetKind() != left.GetKind() {
-		RuntimeError("Eval Error: type mismatch between "+left.GetKindStr()+" and "+right.GetKindStr(), b.operator)
-		return Object{}, errors.New("type mismatch in binary operation")
+	if right.kind != left.kind {
+		return Object{}, RuntimeError("Eval Error: type mismatch between "+left.GetKindStr()+" and "+right.GetKindStr(), operator)
 	}
 
 	// String concatenation
-	if right.GetKind() == STRING && b.operator.GetType() == PLUS {
-		lStr, lOk := left.GetLiteral().(string)
-		rStr, rOk := right.GetLiteral().(string)
+	if right.kind == kindString && operator.GetType() == PLUS {
+		lStr, lOk := left.ref.(string)
+		rStr, rOk := right.ref.(string)
 
 		if lOk && rOk {
-			return *NewObject(STRING, lStr+rStr), nil
+			err := e.inter.allocate(len(lStr)+len(rStr), operator)
+
+			if err != nil {
+				return Object{}, err
+			}
+			return stringValue(lStr + rStr), nil
 		}
 
-		fmt.Println("Implementation Error: Could not parse string passed in expr -> binary -> Evaluate")
-		os.Exit(7)
+		return Object{}, fmt.Errorf("%w: string operands without strings", ErrInternal)
 	}
 
 	// Report invalid non-numeric operations
-	if right.GetKind() != NUMBER {
-		RuntimeError("Eval Error: invalid binary non-numeric operation", b.operator)
-		return Object{}, errors.New("invalid binary operation on non-numeric")
+	if right.kind != kindNumber {
+		return Object{}, RuntimeError("Eval Error: invalid binary non-numeric operation", operator)
 	}
-	lNum, lOk := left.GetLiteral().(float64)
-	rNum, rOk := right.GetLiteral().(float64)
+	lNum, rNum := left.num, right.num
 
-	if !lOk || !rOk {
-		fmt.Println("Implementation Error: Could not parse numbers passed in expr -> binary -> Evaluate")
-		os.Exit(8)
-	}
-
-	switch b.operator.GetType() {
+	switch operator.GetType() {
 	case PLUS:
-		return *NewObject(NUMBER, lNum+rNum), nil
+		return numberValue(lNum + rNum), nil
 	case MINUS:
-		return *NewObject(NUMBER, lNum-rNum), nil
+		return numberValue(lNum - rNum), nil
 	case SLASH:
-		return *NewObject(NUMBER, lNum/rNum), nil
+		return numberValue(lNum / rNum), nil
 	case STAR:
-		return *NewObject(NUMBER, lNum*rNum), nil
+		return numberValue(lNum * rNum), nil
 	case GREATER:
 		if lNum > rNum {
-			return *NewObject(TRUE, nil), nil
+			return boolValue(true), nil
 		}
-		return *NewObject(FALSE, nil), nil
+		return boolValue(false), nil
 	case GREATER_EQUAL:
 		if lNum >= rNum {
-			return *NewObject(TRUE, nil), nil
+			return boolValue(true), nil
 		}
-		return *NewObject(FALSE, nil), nil
+		return boolValue(false), nil
 	case LESS:
 		if lNum < rNum {
-			return *NewObject(TRUE, nil), nil
+			return boolValue(true), nil
 		}
-		return *NewObject(FALSE, nil), nil
+		return boolValue(false), nil
 	case LESS_EQUAL:
 		if lNum <= rNum {
-			return *NewObject(TRUE, nil), nil
+			return boolValue(true), nil
 		}
-		return *NewObject(FALSE, nil), nil
+		return boolValue(false), nil
 	}
 
-	RuntimeError("Eval Error: illegal binary operator", b.operator)
-	return Object{}, errors.New("illegal binary operator")
+	return Object{}, RuntimeError("Eval Error: illegal binary operator", operator)
 }
 
 // GROUPING EXPRESSION
@@ -195,10 +191,13 @@ func (g GroupingExpr) Evaluate(e *Environment) (Object, error) {
 // VARIABLE EXPRESSION
 type VarExpr struct {
 	name Token
+	// set by the resolver, depth -1 looks the name up
+	depth int
+	slot  int
 }
 
 func NewVarExpr(n Token) *VarExpr {
-	return &VarExpr{n}
+	return &VarExpr{n, -1, 0}
 }
 
 func (v *VarExpr) GetToken() Token {
@@ -206,17 +205,20 @@ func (v *VarExpr) GetToken() Token {
 }
 
 func (v VarExpr) Evaluate(e *Environment) (Object, error) {
-	return e.Get(v.name)
+	return e.getAt(v.depth, v.slot, v.name)
 }
 
 // ASSIGNMENT EXPRESSION
 type AssignExpr struct {
 	name  Token
 	value Expr
+	// set by the resolver, depth -1 looks the name up
+	depth int
+	slot  int
 }
 
 func NewAssignExpr(name Token, value Expr) *AssignExpr {
-	return &AssignExpr{name, value}
+	return &AssignExpr{name, value, -1, 0}
 }
 
 func (a AssignExpr) Evaluate(e *Environment) (Object, error) {
@@ -226,7 +228,7 @@ func (a AssignExpr) Evaluate(e *Environment) (Object, error) {
 		return value, err
 	}
 
-	err = e.Assign(a.name, value)
+	err = e.assignAt(a.depth, a.slot, a.name, value)
 
 	if err != nil {
 		return Object{}, err
@@ -277,10 +279,21 @@ func NewCallExpr(c Expr, p Token, a []Expr) *CallExpr {
 }
 
 func (c CallExpr) Evaluate(e *Environment) (Object, error) {
+	function, args, err := c.operands(e)
+
+	if err != nil {
+		return Object{}, err
+	}
+
+	return callFunction(e, function, args, c.paren)
+}
+
+// Evaluate the callee and arguments of a call
+func (c CallExpr) operands(e *Environment) (Callable, []Object, error) {
 	callee, err := c.callee.Evaluate(e)
 
 	if err != nil {
-		return Object{}, nil
+		return nil, nil, err
 	}
 
 	var args []Object
@@ -289,23 +302,54 @@ func (c CallExpr) Evaluate(e *Environment) (Object, error) {
 		obj, err2 := argument.Evaluate(e)
 
 		if err2 != nil {
-			return obj, err2
+			return nil, nil, err2
 		}
 
 		args = append(args, obj)
 	}
 
-	function, ok := callee.literal.(Callable)
+	function, ok := callee.ref.(Callable)
 
 	if !ok {
-		return Object{}, errors.New("type was not of a callable type")
+		return nil, nil, RuntimeError("type was not of a callable type", c.paren)
 	}
+	return function, args, nil
+}
+
+// GET EXPRESSION
+type GetExpr struct {
+	object Expr
+	name   Token
+}
+
+func NewGetExpr(o Expr, n Token) *GetExpr {
+	return &GetExpr{o, n}
+}
 
-	if function.Arity() != len(args) {
-		return Object{}, fmt.Errorf(
-			"expected %v arguments, but recieved %v",
-			function.Arity(), len(args))
+func (g GetExpr) Evaluate(e *Environment) (Object, error) {
+	object, err := g.object.Evaluate(e)
+
+	if err != nil {
+		return object, err
 	}
 
-	return function.Call(*e, args)
+	return getProperty(e.inter, object, g.name)
+}
+
+// Read the property called name from an evaluated object
+func getProperty(inter *Interpreter, object Object, name Token) (Object, error) {
+	if object.kind != kindHost {
+		return Object{}, RuntimeError("Only host objects have properties, got "+object.GetKindStr(), name)
+	}
+
+	value, err := hostProperty(object.ref, name.GetLexeme())
+
+	if err == nil {
+		err = inter.adopt(value)
+	}
+
+	if err != nil {
+		return Object{}, RuntimeErrorCause(err, name)
+	}
+	return value, nil
 }
@@ -0,0 +1,30 @@
+package almond
+
+import "testing"
+
+// Numbers compare by value, not just by kind
+func TestEqualityComparesValues(t *testing.T) {
+	cases := map[string]string{
+		`1 == 1`:       "TRUE",
+		`1 == 2`:       "FALSE",
+		`1 != 2`:       "TRUE",
+		`2.5 != 2.5`:   "FALSE",
+		`"a" == "a"`:   "TRUE",
+		`"a" == "b"`:   "FALSE",
+		`null == null`: "TRUE",
+		`1 == "1"`:     "FALSE",
+	}
+
+	for src, want := range cases {
+		statements := NewParser(NewTokenizer(src + ";").Tokenize()).Parse()
+		got, err := statements[0].(*ExprStmt).expression.Evaluate(NewEnv())
+
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+
+		if got.String() != want {
+			t.Errorf("%s: got %s, want %s", src, got.String(), want)
+		}
+	}
+}
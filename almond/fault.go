@@ -1,34 +1,157 @@
 package almond
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 )
 
-var HadFault bool = false
-var HadRuntimeFault bool = false
+// Raised when a step budget or deadline runs out
+var ErrExecutionLimit = errors.New("execution limit exceeded")
+
+// Raised when a new value does not fit in the memory limit
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// A bug in almond itself rather than in the script
+var ErrInternal = errors.New("internal error")
+
+// Error found while tokenizing or parsing
+type ParseFault struct {
+	Line    int
+	Where   string
+	Message string
+}
+
+func (p *ParseFault) Error() string {
+	return fmt.Sprintf("[line %d] Error%s: %s", p.Line, p.Where, p.Message)
+}
 
 // Helper to format error
-func report(line int, where, message string) {
-	fmt.Printf("[line %d] Error%s: %s\n", line, where, message)
-	HadFault = true
+func report(out io.Writer, line int, where, message string) *ParseFault {
+	fault := &ParseFault{line, where, message}
+	fmt.Fprintln(out, fault.Error())
+	return fault
 }
 
-// Report errors using tokens
-func TokenError(token Token, message string) {
+// Helper to describe where a token error happened
+func tokenWhere(token Token) string {
 	if token.GetType() == EOF {
-		report(token.GetLine(), " at end", message)
-	} else {
-		report(token.GetLine(), " at '"+token.GetLexeme()+"'", message)
+		return " at end"
 	}
+	return " at '" + token.GetLexeme() + "'"
+}
+
+// Report errors using tokens
+func TokenError(token Token, message string) {
+	report(os.Stdout, token.GetLine(), tokenWhere(token), message)
 }
 
 // Submit an error
 func Error(line int, message string) {
-	report(line, "", message)
+	report(os.Stdout, line, "", message)
+}
+
+// Error raised while evaluating a program
+type RuntimeFault struct {
+	Message string
+	Line    int
+	Err     error
+	// Calls the error passed through, innermost first
+	Trace []TraceFrame
+
+	// already passed to Hooks.OnError
+	hooked bool
+	// calls past maxTraceFrames
+	dropped int
+}
+
+// Frames kept in a trace, deep recursion reports the rest as a count
+const maxTraceFrames = 32
+
+// Function call on the way out of a runtime error
+type TraceFrame struct {
+	Function string
+	// Line of the call, 0 when called from Go
+	Line int
+	// Tail calls that reused this frame and are missing from the trace
+	Elided int
+}
+
+func (t TraceFrame) String() string {
+	out := "in " + t.Function
+
+	if t.Line != 0 {
+		out += fmt.Sprintf(" called at line %d", t.Line)
+	}
+
+	if t.Elided == 1 {
+		out += " (1 tail call elided)"
+	} else if t.Elided > 1 {
+		out += fmt.Sprintf(" (%d tail calls elided)", t.Elided)
+	}
+	return out
+}
+
+func (r *RuntimeFault) Error() string {
+	// calls made from Go have no source line
+	if r.Line == 0 {
+		return r.Message
+	}
+	return fmt.Sprintf("%s at line %d", r.Message, r.Line)
+}
+
+func (r *RuntimeFault) Unwrap() error {
+	return r.Err
+}
+
+// Trace one line per call, innermost first
+func (r *RuntimeFault) StackTrace() string {
+	var out strings.Builder
+
+	for _, frame := range r.Trace {
+		out.WriteString("  " + frame.String() + "\n")
+	}
+
+	if r.dropped > 0 {
+		fmt.Fprintf(&out, "  ... %d more calls\n", r.dropped)
+	}
+	return out.String()
+}
+
+// Record a call the error is leaving
+func (r *RuntimeFault) addFrame(function string, line int, elided int) {
+	if len(r.Trace) >= maxTraceFrames {
+		r.dropped++
+		return
+	}
+	r.Trace = append(r.Trace, TraceFrame{function, line, elided})
 }
 
 // Runtime Error
-func RuntimeError(message string, tok Token) {
-	fmt.Printf("%s\n[line %d] ", message, tok.GetLine())
-	HadRuntimeFault = true
+func RuntimeError(message string, tok Token) *RuntimeFault {
+	return &RuntimeFault{Message: message, Line: tok.GetLine()}
+}
+
+// Runtime Error caused by a host side error
+func RuntimeErrorCause(cause error, tok Token) *RuntimeFault {
+	fault := RuntimeError(cause.Error(), tok)
+	fault.Err = cause
+	return fault
+}
+
+// Print a runtime error in the console format
+func printRuntimeError(out io.Writer, err error) {
+	var fault *RuntimeFault
+
+	if errors.As(err, &fault) {
+		fmt.Fprintf(out, "%s\n[line %d] ", fault.Message, fault.Line)
+
+		if len(fault.Trace) > 0 {
+			fmt.Fprint(out, "\n"+fault.StackTrace())
+		}
+	} else {
+		fmt.Fprintln(out, err)
+	}
 }
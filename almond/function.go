@@ -0,0 +1,80 @@
+package almond
+
+import (
+	"context"
+	"errors"
+)
+
+// Handle for calling a script function from Go
+type Function struct {
+	inter *Interpreter
+	fn    Callable
+}
+
+// Wrap a callable, script functions run in the interpreter that declared them
+func NewFunction(fn Callable) *Function {
+	var inter *Interpreter
+
+	if f, ok := fn.(*FunctionCall); ok {
+		inter = f.closure.inter
+	}
+	return &Function{inter, fn}
+}
+
+// Name shown in errors and when printed
+func (f *Function) Name() string {
+	return callName(f.fn)
+}
+
+// Number of expected arguments, negative when any count is accepted
+func (f *Function) Arity() int {
+	return f.fn.Arity()
+}
+
+// Call with Go arguments converted by FromGo, a failed call returns null
+func (f *Function) Call(args ...any) (Value, error) {
+	return f.CallContext(context.Background(), args...)
+}
+
+// Call until done or ctx is cancelled
+func (f *Function) CallContext(ctx context.Context, args ...any) (Value, error) {
+	objects := make([]Object, len(args))
+
+	for idx, arg := range args {
+		obj, err := FromGo(arg)
+
+		if err != nil {
+			return nullValue(), err
+		}
+		objects[idx] = obj
+	}
+
+	// natives do not belong to an interpreter, without a prelude this cannot fail
+	if f.inter == nil {
+		inter, _ := NewInterpreterWith(DefaultCapabilities, nil)
+		return inter.call(ctx, f.fn, objects)
+	}
+	return f.inter.call(ctx, f.fn, objects)
+}
+
+// Call a global script function by name
+func (en *Engine) Call(name string, args ...any) (Value, error) {
+	return en.CallContext(context.Background(), name, args...)
+}
+
+// Call a global script function by name until done or ctx is cancelled
+func (en *Engine) CallContext(ctx context.Context, name string, args ...any) (Value, error) {
+	value, ok := en.GetGlobal(name)
+
+	if !ok {
+		return nullValue(), errors.New("Undefined variable '" + name + "'.")
+	}
+
+	fn, ok := value.ref.(Callable)
+
+	if !ok {
+		return nullValue(), errors.New("'" + name + "' is not callable, got " + value.GetKindStr())
+	}
+
+	return (&Function{en.inter, fn}).CallContext(ctx, args...)
+}
@@ -0,0 +1,171 @@
+package almond
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEngineCall(t *testing.T) {
+	for _, treeWalk := range []bool{false, true} {
+		engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, TreeWalk: treeWalk})
+
+		_, err := engine.Eval(`
+			fn add(a, b) { return a + b; }
+			fn describe(x) { if (x == null) return "null"; return x; }
+			fn apply(f, x) { return f(x); }
+			fn nothing() {}
+			var total = 0;
+			fn bump(n) { total = total + n; return total; }`)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// arguments are converted by FromGo
+		calls := []struct {
+			name string
+			args []any
+			want string
+		}{
+			{"add", []any{1, 2.5}, "3.5"},
+			{"add", []any{int64(-4), uint8(6)}, "2"},
+			{"add", []any{"al", "mond"}, "almond"},
+			{"describe", []any{nil}, "null"},
+			{"describe", []any{true}, "TRUE"},
+			{"nothing", nil, "NULL"},
+			{"abs", []any{-3}, "3"},
+			{"bump", []any{2}, "2"},
+			{"bump", []any{3}, "5"},
+		}
+
+		for _, c := range calls {
+			if value, err := engine.Call(c.name, c.args...); err != nil || value.String() != c.want {
+				t.Errorf("tree %v, %s%v: got %s, %v", treeWalk, c.name, c.args, value.String(), err)
+			}
+		}
+
+		// functions pass through as handles
+		add, _ := engine.GetGlobal("add")
+		handle := ToGo(add).(*Function)
+
+		if value, err := engine.Call("apply", handle, 4); err == nil || value.String() != "NULL" {
+			t.Errorf("tree %v: wrong arity through apply: got %s, %v", treeWalk, value.String(), err)
+		}
+
+		if value, err := handle.Call(20, 22); err != nil || value.String() != "42" || handle.Name() != "fn add" || handle.Arity() != 2 {
+			t.Errorf("tree %v: handle: got %s, %v", treeWalk, value.String(), err)
+		}
+
+		if global, _ := engine.GetGlobal("total"); global.String() != "5" {
+			t.Errorf("tree %v: total is %s", treeWalk, global.String())
+		}
+	}
+}
+
+// Failed calls return null along with the error
+func TestEngineCallErrors(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+	engine.Eval(`var n = 1; fn f(x) { return missing; } fn id(x) { return x; }`)
+
+	cases := []struct {
+		name string
+		args []any
+		want string
+	}{
+		{"nope", nil, "Undefined variable 'nope'."},
+		{"n", nil, "'n' is not callable, got NUMBER"},
+		{"f", []any{1}, "Undefined variable 'missing'. at line 1"},
+		{"id", nil, "expected 1 arguments, but recieved 0"},
+		{"id", []any{make(chan int)}, "unsupported Go type chan int"},
+	}
+
+	for _, c := range cases {
+		value, err := engine.Call(c.name, c.args...)
+
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got %v, want %q", c.name, err, c.want)
+		}
+
+		if value.String() != "NULL" {
+			t.Errorf("%s: returned %s", c.name, value.String())
+		}
+	}
+}
+
+// A native calling back into a failing script function raises the script's
+// error once, with the native in its trace
+func TestErrorsInCallbacks(t *testing.T) {
+	for _, treeWalk := range []bool{false, true} {
+		engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, TreeWalk: treeWalk})
+		engine.RegisterFunc("each", func(fn *Function, n float64) error {
+			for idx := 0.0; idx < n; idx++ {
+				if _, err := fn.Call(idx); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		_, err := engine.Eval(`fn f(x) {
+				if (x == 2) return missing;
+				print x;
+			}
+			each(f, 5);`)
+
+		var fault *RuntimeFault
+
+		if !errors.As(err, &fault) || err.Error() != "Undefined variable 'missing'. at line 2" {
+			t.Fatalf("tree %v: got %v", treeWalk, err)
+		}
+
+		if trace := fault.StackTrace(); trace != "  in fn f\n  in <native fn each> called at line 5\n" {
+			t.Errorf("tree %v: trace %q", treeWalk, trace)
+		}
+
+		// plain Go errors are still raised at the call
+		engine.RegisterFunc("fail", func() error { return errors.New("broken") })
+
+		if _, err := engine.Eval(`fail();`); err == nil || err.Error() != "fail: broken at line 1" {
+			t.Errorf("tree %v: got %v", treeWalk, err)
+		}
+	}
+}
+
+func TestCallContextCancel(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+	engine.Eval(`fn spin() { while (true) {} } fn nap() { sleepMS(10000); }`)
+
+	for _, name := range []string{"spin", "nap"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		value, err := engine.CallContext(ctx, name)
+		cancel()
+
+		if !errors.Is(err, context.DeadlineExceeded) || value.String() != "NULL" {
+			t.Errorf("%s: got %s, %v", name, value.String(), err)
+		}
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("%s: took %v", name, elapsed)
+		}
+	}
+
+	// an already cancelled context stops the call before it starts
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fn, _ := engine.GetGlobal("spin")
+
+	if _, err := ToGo(fn).(*Function).CallContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled: got %v", err)
+	}
+
+	// the engine is usable afterwards
+	if _, err := engine.Call("abs", 1); err != nil {
+		t.Errorf("after cancel: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package almond
+
+import (
+	"errors"
+	"sort"
+)
+
+// Source position reported to hooks
+type Position struct {
+	Line int
+}
+
+// Callbacks for profilers, coverage tools and debuggers.
+// Hooks run on the interpreter goroutine and must not keep the EnvView.
+type Hooks interface {
+	// Called before each statement runs
+	BeforeStmt(pos Position, stmt Stmt, env EnvView)
+	// Called when a function is entered, pos is the call site
+	EnterCall(pos Position, name string, args []Value, env EnvView)
+	// Called when a function returns normally. A function returning a tail
+	// call exits with a null result before the callee is entered.
+	ExitCall(pos Position, name string, result Value, env EnvView)
+	// Called once for each runtime error, where it was raised
+	OnError(pos Position, err error, env EnvView)
+}
+
+// No-op Hooks to embed when only some callbacks are needed
+type BaseHooks struct{}
+
+func (BaseHooks) BeforeStmt(pos Position, stmt Stmt, env EnvView)                {}
+func (BaseHooks) EnterCall(pos Position, name string, args []Value, env EnvView) {}
+func (BaseHooks) ExitCall(pos Position, name string, result Value, env EnvView)  {}
+func (BaseHooks) OnError(pos Position, err error, env EnvView)                   {}
+
+// Read-only view of the variables visible at a point in the program
+type EnvView struct {
+	env *Environment
+}
+
+// Look up a visible variable
+func (v EnvView) Get(name string) (Value, bool) {
+	return v.env.lookup(name)
+}
+
+// Names visible from this scope, sorted
+func (v EnvView) Names() []string {
+	seen := map[string]bool{}
+	names := []string{}
+
+	for env := v.env; env != nil; env = env.enclosing {
+		for _, name := range env.names() {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Names declared in the innermost scope, sorted
+func (v EnvView) Locals() []string {
+	names := v.env.names()
+
+	sort.Strings(names)
+	return names
+}
+
+// Install hooks, nil removes them
+func (i *Interpreter) SetHooks(hooks Hooks) {
+	i.hooks = hooks
+}
+
+// Run one statement, reporting to the hooks when installed
+func (i *Interpreter) execute(statement Stmt, env *Environment) error {
+	if i == nil || i.hooks == nil {
+		return statement.Evaluate(env)
+	}
+
+	i.hooks.BeforeStmt(Position{statement.Line()}, statement, EnvView{env})
+	err := statement.Evaluate(env)
+	i.hookError(err, env)
+	return err
+}
+
+// Report a runtime error to the hooks the first time it is seen
+func (i *Interpreter) hookError(err error, env *Environment) {
+	var fault *RuntimeFault
+
+	if err == nil || !errors.As(err, &fault) || fault.hooked {
+		return
+	}
+
+	fault.hooked = true
+	i.hooks.OnError(Position{fault.Line}, fault, EnvView{env})
+}
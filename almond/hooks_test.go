@@ -0,0 +1,225 @@
+package almond
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Hooks that log every event as a line of text
+type eventLog struct {
+	events []string
+	locals map[int][]string
+	names  map[int][]string
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{locals: map[int][]string{}, names: map[int][]string{}}
+}
+
+func (l *eventLog) BeforeStmt(pos Position, stmt Stmt, env EnvView) {
+	l.events = append(l.events, fmt.Sprintf("stmt %d", pos.Line))
+	l.locals[pos.Line] = env.Locals()
+	l.names[pos.Line] = env.Names()
+}
+
+func (l *eventLog) EnterCall(pos Position, name string, args []Value, env EnvView) {
+	strs := make([]string, len(args))
+	for idx, arg := range args {
+		strs[idx] = arg.String()
+	}
+	l.events = append(l.events, fmt.Sprintf("enter %s %d (%s)", name, pos.Line, strings.Join(strs, ", ")))
+}
+
+func (l *eventLog) ExitCall(pos Position, name string, result Value, env EnvView) {
+	l.events = append(l.events, fmt.Sprintf("exit %s %d %s", name, pos.Line, result.String()))
+}
+
+func (l *eventLog) OnError(pos Position, err error, env EnvView) {
+	l.events = append(l.events, fmt.Sprintf("error %d", pos.Line))
+}
+
+func TestHookOrder(t *testing.T) {
+	cases := []struct {
+		src  string
+		want []string
+	}{
+		{
+			`var x = 1;
+			fn add(a, b) {
+				var sum = a + b;
+				return sum;
+			}
+			print add(x, 2);`,
+			[]string{"stmt 1", "stmt 2", "stmt 6", "enter fn add 6 (1, 2)", "stmt 3", "stmt 4", "exit fn add 6 3"},
+		},
+		{
+			`fn twice(n) { return n * 2; }
+			twice(twice(1));`,
+			[]string{"stmt 1", "stmt 2", "enter fn twice 2 (1)", "stmt 1", "exit fn twice 2 2", "enter fn twice 2 (2)", "stmt 1", "exit fn twice 2 4"},
+		},
+		{
+			`fn bad() {
+				return missing;
+			}
+			bad();
+			print "never";`,
+			// raised once where it happened, failed calls do not exit
+			[]string{"stmt 1", "stmt 4", "enter fn bad 4 ()", "stmt 2", "error 2"},
+		},
+		{
+			`var n = clock() - clock();`,
+			[]string{"stmt 1", "enter <NATIVE CLK FN> 1 ()", "exit <NATIVE CLK FN> 1 0", "enter <NATIVE CLK FN> 1 ()", "exit <NATIVE CLK FN> 1 0"},
+		},
+	}
+
+	for _, c := range cases {
+		log := newEventLog()
+		engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Hooks: log})
+		engine.Eval(c.src)
+
+		got := log.events
+
+		// clock readings vary between runs
+		for idx, event := range got {
+			if strings.HasPrefix(event, "exit <NATIVE CLK FN>") {
+				got[idx] = "exit <NATIVE CLK FN> 1 0"
+			}
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\ngot  %q\nwant %q", c.src, got, c.want)
+		}
+	}
+}
+
+func TestEnvView(t *testing.T) {
+	log := newEventLog()
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Hooks: log})
+
+	_, err := engine.Eval(`var x = 1;
+		fn add(a, b) {
+			var sum = a + b;
+			return sum;
+		}
+		add(x, 2);`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := log.locals[4]; !reflect.DeepEqual(got, []string{"a", "b", "sum"}) {
+		t.Errorf("function locals: got %q", got)
+	}
+
+	if got := log.locals[6]; !contains(got, "x") || !contains(got, "add") || contains(got, "sum") {
+		t.Errorf("global locals: got %q", got)
+	}
+
+	// names reach through every scope and include natives and the prelude
+	names := log.names[4]
+
+	for _, name := range []string{"a", "b", "sum", "x", "add", "clock", "abs"} {
+		if !contains(names, name) {
+			t.Errorf("names: missing %s in %q", name, names)
+		}
+	}
+
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("names are not sorted: %q", names)
+	}
+
+	for idx := 1; idx < len(names); idx++ {
+		if names[idx] == names[idx-1] {
+			t.Errorf("names: %s listed twice", names[idx])
+		}
+	}
+}
+
+// A local shadowing a global is listed once and Get finds the inner value
+func TestEnvViewGet(t *testing.T) {
+	var view EnvView
+	var seen Value
+
+	hooks := &viewHooks{line: 3, view: func(env EnvView) {
+		view = env
+		seen, _ = env.Get("x")
+	}}
+
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Hooks: hooks})
+	engine.Eval(`var x = "global";
+		fn f(x) {
+			return x;
+		}
+		f("local");`)
+
+	if seen.String() != "local" {
+		t.Errorf("Get: got %s", seen.String())
+	}
+
+	count := 0
+	for _, name := range view.Names() {
+		if name == "x" {
+			count++
+		}
+	}
+
+	if count != 1 {
+		t.Errorf("x listed %d times", count)
+	}
+}
+
+type viewHooks struct {
+	BaseHooks
+	line int
+	view func(env EnvView)
+}
+
+func (h *viewHooks) BeforeStmt(pos Position, stmt Stmt, env EnvView) {
+	if pos.Line == h.line {
+		h.view(env)
+	}
+}
+
+// Hooked code runs on the tree-walker, which reports every statement
+func TestHooksForceTreeWalk(t *testing.T) {
+	log := newEventLog()
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Hooks: log})
+
+	if !engine.inter.walking() {
+		t.Fatal("hooked engine runs on the vm")
+	}
+
+	// the prelude loads before hooks are installed
+	if len(log.events) != 0 {
+		t.Errorf("prelude reported %q", log.events)
+	}
+
+	engine.Eval(`var i = 0; while (i < 3) i = i + 1;`)
+
+	if len(log.events) != 5 {
+		t.Errorf("got %q", log.events)
+	}
+
+	engine.inter.SetHooks(nil)
+
+	if engine.inter.walking() {
+		t.Error("engine without hooks stays on the tree-walker")
+	}
+
+	if _, err := engine.Eval(`i = i + 1;`); err != nil || len(log.events) != 5 {
+		t.Errorf("removed hooks: got %q, %v", log.events, err)
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package almond
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Exported members of a Go type, looked up by name
+type hostMembers struct {
+	fields  map[string][]int
+	methods map[string]int
+}
+
+// reflect.Type -> *hostMembers
+var hostCache sync.Map
+
+// Wrap a Go struct pointer so scripts can read its fields and call its methods
+func WrapStruct(ptr any) (Value, error) {
+	value := reflect.ValueOf(ptr)
+
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return Object{}, fmt.Errorf("expected a non-nil struct pointer, got %T", ptr)
+	}
+
+	return hostValue(ptr), nil
+}
+
+// Build or fetch the member table of a type
+func membersOf(t reflect.Type) *hostMembers {
+	if cached, ok := hostCache.Load(t); ok {
+		return cached.(*hostMembers)
+	}
+
+	members := &hostMembers{map[string][]int{}, map[string]int{}}
+
+	// Method only lists exported methods
+	for idx := 0; idx < t.NumMethod(); idx++ {
+		members.methods[t.Method(idx).Name] = idx
+	}
+
+	structType := t
+	if structType.Kind() == reflect.Pointer {
+		structType = structType.Elem()
+	}
+
+	if structType.Kind() == reflect.Struct {
+		for _, field := range reflect.VisibleFields(structType) {
+			if field.IsExported() && !field.Anonymous {
+				members.fields[field.Name] = field.Index
+			}
+		}
+	}
+
+	cached, _ := hostCache.LoadOrStore(t, members)
+	return cached.(*hostMembers)
+}
+
+// Read a field or bind a method of a host value
+func hostProperty(host any, name string) (Object, error) {
+	value := reflect.ValueOf(host)
+	members := membersOf(value.Type())
+
+	if idx, ok := members.methods[name]; ok {
+		native, err := NewNativeFunc(name, value.Method(idx).Interface())
+
+		if err != nil {
+			return Object{}, err
+		}
+		return callableValue(native), nil
+	}
+
+	if index, ok := members.fields[name]; ok {
+		if value.Kind() == reflect.Pointer {
+			value = value.Elem()
+		}
+
+		field, err := value.FieldByIndexErr(index)
+
+		if err != nil {
+			return Object{}, err
+		}
+
+		// keep nested structs addressable so their methods work
+		if field.Kind() == reflect.Struct && field.CanAddr() {
+			return hostValue(field.Addr().Interface()), nil
+		}
+		return toObject(field)
+	}
+
+	return Object{}, errors.New("Undefined property '" + name + "' on " + value.Type().String())
+}
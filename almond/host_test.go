@@ -0,0 +1,153 @@
+package almond
+
+import (
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type hostAddress struct {
+	City string
+	Zip  int
+}
+
+func (a *hostAddress) Label() string {
+	return a.City + " " + strconv.Itoa(a.Zip)
+}
+
+type hostBase struct {
+	ID int
+}
+
+type hostPerson struct {
+	hostBase
+	Name string
+	Age  int
+	Home hostAddress
+
+	secret string
+}
+
+func (p hostPerson) Greet(greeting string) string {
+	return greeting + " " + p.Name
+}
+
+func (p *hostPerson) Birthday() int {
+	p.Age++
+	return p.Age
+}
+
+func (p *hostPerson) hidden() string {
+	return p.secret
+}
+
+func newHostPerson() *hostPerson {
+	return &hostPerson{hostBase{7}, "ada", 36, hostAddress{"london", 1815}, "pin"}
+}
+
+func TestHostMembers(t *testing.T) {
+	values := [][2]string{
+		{`p.Name;`, "ada"},
+		{`p.Age + 1;`, "37"},
+		// fields of embedded structs are promoted
+		{`p.ID;`, "7"},
+		{`p.Home.City;`, "london"},
+		{`p.Home.Zip;`, "1815"},
+		// methods with value and pointer receivers
+		{`p.Greet("hi");`, "hi ada"},
+		{`p.Home.Label();`, "london 1815"},
+		{`var greet = p.Greet; greet("yo");`, "yo ada"},
+		{`p.Birthday(); p.Birthday();`, "38"},
+	}
+
+	for _, treeWalk := range []bool{false, true} {
+		person := newHostPerson()
+		value, err := WrapStruct(person)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, TreeWalk: treeWalk})
+		engine.SetGlobal("p", value)
+
+		for _, c := range values {
+			if value, err := engine.Eval(c[0]); err != nil || value.String() != c[1] {
+				t.Errorf("tree %v, %s: got %s, %v", treeWalk, c[0], value.String(), err)
+			}
+		}
+
+		// scripts and the host share the struct
+		if person.Age != 38 {
+			t.Errorf("tree %v: Birthday did not update the struct, age %d", treeWalk, person.Age)
+		}
+
+		person.Home.City = "paris"
+
+		if value, err := engine.Eval(`var home = p.Home; home.City;`); err != nil || value.String() != "paris" {
+			t.Errorf("tree %v: nested struct is a copy: got %s, %v", treeWalk, value.String(), err)
+		}
+	}
+}
+
+func TestHostMemberErrors(t *testing.T) {
+	value, _ := WrapStruct(newHostPerson())
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+	engine.SetGlobal("p", value)
+
+	cases := map[string]string{
+		// unexported members are hidden
+		`p.secret;`:          "Undefined property 'secret' on *almond.hostPerson",
+		`p.hidden();`:        "Undefined property 'hidden' on *almond.hostPerson",
+		`p.hostBase;`:        "Undefined property 'hostBase' on *almond.hostPerson",
+		`p.Missing;`:         "Undefined property 'Missing' on *almond.hostPerson",
+		`p.Home.Nope;`:       "Undefined property 'Nope' on *almond.hostAddress",
+		`p.Greet(1);`:        "Greet",
+		`var n = 1; n.Name;`: "Only host objects have properties",
+	}
+
+	for src, want := range cases {
+		if _, err := engine.Eval(src); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+
+	// a struct held by value only has its value receiver methods
+	if _, err := hostProperty(hostPerson{Name: "bo"}, "Birthday"); err == nil {
+		t.Error("pointer method found on a struct value")
+	}
+
+	if greet, err := hostProperty(hostPerson{Name: "bo"}, "Greet"); err != nil || greet.kind != kindCallable {
+		t.Errorf("value method: got %v, %v", greet, err)
+	}
+}
+
+func TestWrapStructRejects(t *testing.T) {
+	var person *hostPerson
+	number := 3
+
+	for _, ptr := range []any{nil, person, hostPerson{}, &number, "text"} {
+		if _, err := WrapStruct(ptr); err == nil || !strings.Contains(err.Error(), "expected a non-nil struct pointer") {
+			t.Errorf("%#v: got %v", ptr, err)
+		}
+	}
+}
+
+// Member tables are built once per type
+func TestMembersOfIsCached(t *testing.T) {
+	first := membersOf(reflect.TypeOf(&hostPerson{}))
+
+	if membersOf(reflect.TypeOf(&hostPerson{})) != first {
+		t.Error("member table was rebuilt")
+	}
+
+	if _, ok := first.fields["secret"]; ok {
+		t.Error("unexported field listed")
+	}
+
+	if len(first.methods) != 2 {
+		t.Errorf("got methods %v", first.methods)
+	}
+}
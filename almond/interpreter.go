@@ -1,19 +1,349 @@
 package almond
 
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Default limit on nested function calls
+const DefaultMaxCallDepth = 1000
+
 type Interpreter struct {
-	env Environment
+	env      Environment
+	hooks    Hooks
+	depth    int
+	maxDepth int
+
+	// print output, flushed when a run ends
+	stdout       *bufio.Writer
+	lineBuffered bool
+
+	// bytecode vm state
+	stack    []Object
+	treeWalk bool
+
+	// run statements as parsed
+	noOptimize bool
+
+	// execution budgets
+	ctx      context.Context
+	steps    int
+	maxSteps int
+	timeout  time.Duration
+
+	// approximate bytes of strings held by variables
+	allocated int64
+	maxMemory int64
+}
+
+// Rough per-value overhead added to every allocation
+const valueHeaderSize = 16
+
+func NewInterpreter() (*Interpreter, error) {
+	return NewSandboxInterpreter(DefaultCapabilities)
 }
 
-func NewInterpreter() *Interpreter {
-	return &Interpreter{*NewEnv()}
+// Interpreter whose scripts only get the natives allowed by caps
+func NewSandboxInterpreter(caps Capabilities) (*Interpreter, error) {
+	prelude, err := DefaultPrelude()
+
+	if err != nil {
+		return nil, err
+	}
+
+	inter, err := NewInterpreterWith(caps, prelude)
+
+	// the embedded prelude only uses the language itself
+	if err != nil {
+		return nil, fmt.Errorf("%w: embedded prelude failed to load: %w", ErrInternal, err)
+	}
+	return inter, nil
+}
+
+// Interpreter with the natives allowed by caps and a prelude, nil skips the prelude
+func NewInterpreterWith(caps Capabilities, prelude *Prelude) (*Interpreter, error) {
+	inter := &Interpreter{env: *NewSandboxEnv(caps), stdout: bufio.NewWriter(os.Stdout), maxDepth: DefaultMaxCallDepth}
+	inter.env.inter = inter
+	inter.env.enclosing.inter = inter
+
+	if prelude != nil {
+		err := prelude.load(inter)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return inter, nil
+}
+
+// Set where print statements write, output is buffered until a run ends
+func (i *Interpreter) SetOutput(out io.Writer) {
+	i.Flush()
+	i.stdout = bufio.NewWriter(out)
+}
+
+// Flush print output after every line instead of when the buffer fills, off by default
+func (i *Interpreter) SetLineBuffered(enabled bool) {
+	i.lineBuffered = enabled
+}
+
+// Write out buffered print output
+func (i *Interpreter) Flush() error {
+	return i.stdout.Flush()
+}
+
+// Write a line of print output
+func (i *Interpreter) print(line string) {
+	i.stdout.WriteString(line)
+	i.stdout.WriteByte('\n')
+
+	if i.lineBuffered {
+		i.stdout.Flush()
+	}
+}
+
+// Limit nested calls before a stack overflow is raised, 0 disables the limit
+func (i *Interpreter) SetMaxCallDepth(depth int) {
+	i.maxDepth = depth
+}
+
+// Limit loop iterations and calls per run, 0 disables the limit
+func (i *Interpreter) SetStepLimit(steps int) {
+	i.maxSteps = steps
+}
+
+// Limit wall-clock time per run, 0 disables the limit
+func (i *Interpreter) SetTimeout(timeout time.Duration) {
+	i.timeout = timeout
+}
+
+// Limit approximate bytes held by variables, 0 disables the limit. Making a
+// string that does not fit next to what is held fails, values are given back
+// when reassigned or when their scope ends.
+func (i *Interpreter) SetMemoryLimit(bytes int64) {
+	i.maxMemory = bytes
+}
+
+// Fold constants and drop dead code before running, on by default
+func (i *Interpreter) SetOptimize(enabled bool) {
+	i.noOptimize = !enabled
+}
+
+// Approximate bytes currently held by variables
+func (i *Interpreter) MemoryUsage() int64 {
+	return i.allocated
+}
+
+// Run statements and return the first runtime error
+func (i *Interpreter) Interpret(statements []Stmt) error {
+	return i.InterpretContext(context.Background(), statements)
+}
+
+// Run statements until done, cancelled or out of budget
+func (i *Interpreter) InterpretContext(ctx context.Context, statements []Stmt) error {
+	_, err := i.evaluate(ctx, statements)
+	return err
+}
+
+// Reset budgets for a new run, the returned func ends it
+func (i *Interpreter) begin(ctx context.Context) func() {
+	cancel := context.CancelFunc(func() {})
+
+	if i.timeout > 0 {
+		ctx, cancel = context.WithTimeoutCause(ctx, i.timeout, ErrExecutionLimit)
+	}
+
+	i.ctx = ctx
+	i.depth = 0
+	i.steps = 0
+
+	return func() {
+		cancel()
+		i.ctx = nil
+		i.Flush()
+	}
+}
+
+// Call a script function from Go, joining the current run if there is one
+func (i *Interpreter) call(ctx context.Context, function Callable, args []Object) (Object, error) {
+	if i.ctx == nil {
+		defer i.begin(ctx)()
+	}
+
+	err := i.adopt(args...)
+
+	if err != nil {
+		return nullValue(), err
+	}
+
+	value, err := callFunction(&i.env, function, args, Token{})
+
+	// a return value travels as an error
+	if val, ok := err.(*Object); ok {
+		return *val, nil
+	}
+
+	if err != nil {
+		return nullValue(), err
+	}
+	return value, nil
 }
 
-func (i *Interpreter) Interpret(statements []Stmt) {
+// Run statements and keep the value of the last expression statement
+func (i *Interpreter) evaluate(ctx context.Context, statements []Stmt) (Object, error) {
+	defer i.begin(ctx)()
+
+	if !i.noOptimize {
+		statements = optimize(statements)
+	}
+
+	if !i.walking() {
+		// code the vm cannot hold falls back to the tree-walker
+		chunk, err := compile(statements)
+
+		if err == nil {
+			return i.runChunk(chunk, &i.env)
+		}
+	}
+
+	result := nullValue()
+
 	for _, statement := range statements {
-		err := statement.Evaluate(&i.env)
+		var err error
+
+		if x, ok := statement.(*ExprStmt); ok {
+			if i.hooks != nil {
+				i.hooks.BeforeStmt(Position{x.Line()}, x, EnvView{&i.env})
+			}
+			result, err = x.expression.Evaluate(&i.env)
+
+			if i.hooks != nil {
+				i.hookError(err, &i.env)
+			}
+		} else {
+			result = nullValue()
+			err = i.execute(statement, &i.env)
+		}
 
 		if err != nil {
-			break
+			// top level return stops the program
+			if val, ok := err.(*Object); ok {
+				return *val, nil
+			}
+			return Object{}, err
 		}
 	}
+	return result, nil
+}
+
+// Count a step and stop the run if it was cancelled or ran out of budget
+func (i *Interpreter) checkpoint(tok Token) error {
+	if i == nil {
+		return nil
+	}
+
+	if i.maxSteps > 0 {
+		i.steps++
+
+		if i.steps > i.maxSteps {
+			return RuntimeErrorCause(ErrExecutionLimit, tok)
+		}
+	}
+
+	if i.ctx != nil {
+		select {
+		case <-i.ctx.Done():
+			return RuntimeErrorCause(context.Cause(i.ctx), tok)
+		default:
+		}
+	}
+	return nil
+}
+
+// Channel closed when the current run is cancelled
+func (i *Interpreter) done() <-chan struct{} {
+	if i == nil || i.ctx == nil {
+		return nil
+	}
+	return i.ctx.Done()
+}
+
+// Reason the current run was cancelled
+func (i *Interpreter) cause() error {
+	if i == nil || i.ctx == nil {
+		return nil
+	}
+	return context.Cause(i.ctx)
+}
+
+// Fail if a new value of size bytes does not fit next to the held ones
+func (i *Interpreter) allocate(size int, tok Token) error {
+	if i == nil || i.maxMemory <= 0 {
+		return nil
+	}
+
+	if i.allocated+int64(size)+valueHeaderSize > i.maxMemory {
+		return RuntimeErrorCause(ErrMemoryLimit, tok)
+	}
+	return nil
+}
+
+// Check room for values made outside script code: native results, host
+// fields and values set by the host
+func (i *Interpreter) adopt(values ...Object) error {
+	if i == nil || i.maxMemory <= 0 {
+		return nil
+	}
+
+	total := i.allocated
+
+	for _, value := range values {
+		total += heldSize(value)
+	}
+
+	if total > i.maxMemory {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+// Bytes a stored value holds, only strings are counted
+func heldSize(value Object) int64 {
+	if value.kind != kindString {
+		return 0
+	}
+	return int64(len(value.ref.(string))) + valueHeaderSize
+}
+
+// Account for a variable changing from old to value
+func (i *Interpreter) store(old, value Object) {
+	if i != nil {
+		i.allocated += heldSize(value) - heldSize(old)
+	}
+}
+
+// Give back what a scope holds as it is left, unless a closure keeps it
+func (i *Interpreter) release(env *Environment) {
+	if i == nil || env.captured {
+		return
+	}
+
+	for _, value := range env.slots {
+		i.allocated -= heldSize(value)
+	}
+
+	for _, value := range env.lut {
+		i.allocated -= heldSize(value)
+	}
+}
+
+// Release the scopes from env up to, but not including, outer
+func (i *Interpreter) releaseTo(env, outer *Environment) {
+	for ; env != outer && env != nil; env = env.enclosing {
+		i.release(env)
+	}
 }
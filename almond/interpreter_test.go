@@ -0,0 +1,167 @@
+package almond
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parse and run src, parse errors fail the test
+func interpretSource(t *testing.T, inter *Interpreter, src string) error {
+	t.Helper()
+	tokenizer := NewTokenizer(src)
+	parser := NewParser(tokenizer.Tokenize())
+	statements := parser.Parse()
+
+	if faults := append(tokenizer.Faults(), parser.Faults()...); len(faults) > 0 {
+		t.Fatal(errors.Join(faults...))
+	}
+	return inter.Interpret(statements)
+}
+
+// Recursion past the limit is a stack overflow, the limit applies on both backends
+func TestMaxCallDepth(t *testing.T) {
+	src := `fn down(n) { if (n == 0) return 0; return 1 + down(n - 1); } down(%d);`
+
+	for _, treeWalk := range []bool{false, true} {
+		inter, _ := NewInterpreter()
+		inter.SetOutput(io.Discard)
+		inter.SetTreeWalk(treeWalk)
+
+		err := interpretSource(t, inter, fmt.Sprintf(src, DefaultMaxCallDepth+10))
+
+		if err == nil || !strings.Contains(err.Error(), "stack overflow in fn down") {
+			t.Errorf("tree-walk %v, default limit: got %v", treeWalk, err)
+		}
+
+		inter.SetMaxCallDepth(20)
+
+		if err := interpretSource(t, inter, fmt.Sprintf(src, 15)); err != nil {
+			t.Errorf("tree-walk %v, under the limit: %v", treeWalk, err)
+		}
+
+		err = interpretSource(t, inter, fmt.Sprintf(src, 25))
+
+		var fault *RuntimeFault
+		if !errors.As(err, &fault) || !strings.Contains(fault.Error(), "stack overflow in fn down") {
+			t.Errorf("tree-walk %v, over the limit: got %v", treeWalk, err)
+		}
+
+		// the depth unwinds after an overflow
+		if err := interpretSource(t, inter, fmt.Sprintf(src, 15)); err != nil {
+			t.Errorf("tree-walk %v, after an overflow: %v", treeWalk, err)
+		}
+
+		inter.SetMaxCallDepth(0)
+
+		if err := interpretSource(t, inter, fmt.Sprintf(src, 3*DefaultMaxCallDepth)); err != nil {
+			t.Errorf("tree-walk %v, no limit: %v", treeWalk, err)
+		}
+	}
+}
+
+// The step budget stops runaway loops and is reset for every run
+func TestStepLimit(t *testing.T) {
+	for _, treeWalk := range []bool{false, true} {
+		inter, _ := NewInterpreter()
+		inter.SetOutput(io.Discard)
+		inter.SetTreeWalk(treeWalk)
+		inter.SetStepLimit(100)
+
+		for run := 0; run < 3; run++ {
+			if err := interpretSource(t, inter, `var i = 0; while (i < 60) i = i + 1;`); err != nil {
+				t.Fatalf("tree-walk %v, run %d: %v", treeWalk, run, err)
+			}
+		}
+
+		err := interpretSource(t, inter, `while (true) {}`)
+
+		if !errors.Is(err, ErrExecutionLimit) {
+			t.Errorf("tree-walk %v: got %v", treeWalk, err)
+		}
+
+		err = interpretSource(t, inter, `fn f() { return f(); } f();`)
+
+		if !errors.Is(err, ErrExecutionLimit) {
+			t.Errorf("tree-walk %v, tail calls: got %v", treeWalk, err)
+		}
+	}
+}
+
+// A run past its deadline stops with ErrExecutionLimit, also while sleeping
+func TestTimeout(t *testing.T) {
+	for _, src := range []string{`while (true) {}`, `sleepMS(10000);`} {
+		inter, _ := NewInterpreter()
+		inter.SetTimeout(20 * time.Millisecond)
+
+		start := time.Now()
+		err := interpretSource(t, inter, src)
+
+		if !errors.Is(err, ErrExecutionLimit) {
+			t.Errorf("%s: got %v", src, err)
+		}
+
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: stopped after %v", src, elapsed)
+		}
+	}
+}
+
+// Cancelling the context stops the run with the context's cause
+func TestInterpretContextCancel(t *testing.T) {
+	for _, src := range []string{`while (true) {}`, `sleepMS(10000);`} {
+		inter, _ := NewInterpreter()
+		statements := NewParser(NewTokenizer(src).Tokenize()).Parse()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		err := inter.InterpretContext(ctx, statements)
+
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v", src, err)
+		}
+
+		// the next run is not cancelled
+		if err := interpretSource(t, inter, `var x = 1;`); err != nil {
+			t.Errorf("%s, next run: %v", src, err)
+		}
+	}
+}
+
+// Only strings held by variables count, reassigned and out of scope values are given back
+func TestMemoryLimit(t *testing.T) {
+	inter, _ := NewInterpreter()
+	inter.SetMemoryLimit(1000)
+
+	scripts := []string{
+		`var s = ""; var i = 0; while (i < 20000) { s = "a" + "b"; i = i + 1; }`,
+		`var j = 0; while (j < 2000) { var u = "ab" + "cd"; j = j + 1; }`,
+		`fn local(p) { var v = p + "cd"; return 1; } var k = 0; while (k < 2000) { local("ab"); k = k + 1; }`,
+	}
+
+	for _, src := range scripts {
+		if err := interpretSource(t, inter, src); err != nil {
+			t.Errorf("%s: %v", src, err)
+		}
+	}
+
+	// globals stay held between runs
+	if usage := inter.MemoryUsage(); usage != 2+valueHeaderSize {
+		t.Errorf("usage %d", usage)
+	}
+
+	err := interpretSource(t, inter, `var d = "x"; while (true) d = d + d;`)
+
+	if !errors.Is(err, ErrMemoryLimit) {
+		t.Errorf("doubling: got %v", err)
+	}
+
+	if usage := inter.MemoryUsage(); usage > 1000 {
+		t.Errorf("usage %d is over the limit", usage)
+	}
+}
@@ -0,0 +1,345 @@
+package almond
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Lint rule names, used for toggling and inline ignores
+const (
+	LintUnusedVariable  = "unused-variable"
+	LintShadowing       = "shadowing"
+	LintRedeclaration   = "redeclaration"
+	LintUnreachableCode = "unreachable-code"
+)
+
+// All lint rules in reporting order
+var LintRules = []string{
+	LintUnusedVariable,
+	LintShadowing,
+	LintRedeclaration,
+	LintUnreachableCode,
+}
+
+// Comment prefix that silences rules on its line and the next
+const lintIgnorePrefix = "almond:ignore"
+
+type Severity int
+
+const (
+	SeverityWarning Severity = iota
+)
+
+func (s Severity) String() string {
+	return "Warning"
+}
+
+// Finding produced by the linter
+type Diagnostic struct {
+	Line     int
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("[line %d] %s (%s): %s", d.Line, d.Severity, d.Rule, d.Message)
+}
+
+// Rules switched off for a lint run
+type LintOptions struct {
+	Disabled map[string]bool
+}
+
+// Lint a source file
+func LintFile(filename string, opts LintOptions) ([]Diagnostic, error) {
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return Lint(string(data), opts)
+}
+
+// Lint source code, warnings never stop the code from running.
+// Syntax errors are returned alongside warnings for the parsed parts.
+func Lint(source string, opts LintOptions) ([]Diagnostic, error) {
+	tokenizer := NewTokenizer(source)
+	parser := NewStreamParser(tokenizer)
+	statements := parser.Parse()
+	faults := append(tokenizer.Faults(), parser.Faults()...)
+
+	l := linter{opts: opts, ignores: lintIgnores(tokenizer.Comments())}
+
+	// natives and the prelude are declared around the script
+	l.scopes = []*lintScope{lintBuiltins()}
+
+	l.beginScope()
+	l.stmts(statements)
+	l.endScope()
+
+	// function bodies are linted after the code around them
+	sort.SliceStable(l.diags, func(a, b int) bool {
+		return l.diags[a].Line < l.diags[b].Line
+	})
+
+	return l.diags, errors.Join(faults...)
+}
+
+// Map line -> rules ignored on that line
+func lintIgnores(comments []Comment) map[int]map[string]bool {
+	ignores := map[int]map[string]bool{}
+
+	for _, comment := range comments {
+		text := strings.TrimSpace(comment.Text)
+
+		if !strings.HasPrefix(text, lintIgnorePrefix) {
+			continue
+		}
+
+		rules := strings.FieldsFunc(text[len(lintIgnorePrefix):], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+
+		// no rule listed silences every rule
+		if len(rules) == 0 {
+			rules = LintRules
+		}
+
+		for _, line := range []int{comment.Line, comment.Line + 1} {
+			if ignores[line] == nil {
+				ignores[line] = map[string]bool{}
+			}
+			for _, rule := range rules {
+				ignores[line][rule] = true
+			}
+		}
+	}
+	return ignores
+}
+
+// declared name tracked by the linter
+type lintVar struct {
+	name Token
+	kind string
+	used bool
+	// native or prelude name, it has no line
+	builtin bool
+}
+
+// one lexical scope
+type lintScope struct {
+	names map[string]*lintVar
+	order []*lintVar
+	// functions declared in the scope, their bodies are walked when it ends
+	functions []*FnStmt
+}
+
+type linter struct {
+	opts    LintOptions
+	ignores map[int]map[string]bool
+	scopes  []*lintScope
+	diags   []Diagnostic
+}
+
+// record a warning unless disabled or ignored inline
+func (l *linter) warn(line int, rule, message string) {
+	if l.opts.Disabled[rule] || l.ignores[line][rule] {
+		return
+	}
+	l.diags = append(l.diags, Diagnostic{line, rule, SeverityWarning, message})
+}
+
+// ----- Scope helpers
+
+// Scope of the names every script starts with, whichever natives a run allows
+func lintBuiltins() *lintScope {
+	scope := &lintScope{names: map[string]*lintVar{}}
+
+	add := func(name, kind string) {
+		scope.names[name] = &lintVar{*NewToken(IDENTIFIER, name, "", 0), kind, true, true}
+	}
+
+	for _, name := range NewSandboxEnv(AllCapabilities).enclosing.names() {
+		add(name, "native")
+	}
+
+	// a broken prelude is reported when a script runs
+	prelude, err := DefaultPrelude()
+
+	if err == nil {
+		for _, statement := range prelude.statements {
+			switch s := statement.(type) {
+			case *FnStmt:
+				add(s.name.GetLexeme(), "prelude function")
+			case *VarStmt:
+				add(s.name.GetLexeme(), "prelude variable")
+			}
+		}
+	}
+	return scope
+}
+
+func (l *linter) beginScope() {
+	l.scopes = append(l.scopes, &lintScope{map[string]*lintVar{}, nil, nil})
+}
+
+func (l *linter) endScope() {
+	scope := l.scopes[len(l.scopes)-1]
+
+	// a body runs once the code around it has declared all of its names
+	for idx := 0; idx < len(scope.functions); idx++ {
+		fn := scope.functions[idx]
+
+		l.beginScope()
+		for _, param := range fn.params {
+			l.declare(param, "parameter")
+		}
+		l.stmts(fn.body)
+		l.endScope()
+	}
+
+	l.scopes = l.scopes[:len(l.scopes)-1]
+
+	for _, v := range scope.order {
+		if v.kind == "variable" && !v.used {
+			l.warn(v.name.GetLine(), LintUnusedVariable,
+				"variable '"+v.name.GetLexeme()+"' is never read")
+		}
+	}
+}
+
+// add a name to the innermost scope
+func (l *linter) declare(name Token, kind string) {
+	scope := l.scopes[len(l.scopes)-1]
+	lexeme := name.GetLexeme()
+
+	if prev, ok := scope.names[lexeme]; ok {
+		l.warn(name.GetLine(), LintRedeclaration, fmt.Sprintf(
+			"%s '%s' redeclares %s from line %d",
+			kind, lexeme, prev.kind, prev.name.GetLine()))
+	} else {
+		for idx := len(l.scopes) - 2; idx >= 0; idx-- {
+			outer, ok := l.scopes[idx].names[lexeme]
+
+			if ok && outer.builtin {
+				l.warn(name.GetLine(), LintShadowing, fmt.Sprintf(
+					"%s '%s' shadows a %s", kind, lexeme, outer.kind))
+				break
+			} else if ok {
+				l.warn(name.GetLine(), LintShadowing, fmt.Sprintf(
+					"%s '%s' shadows %s from line %d",
+					kind, lexeme, outer.kind, outer.name.GetLine()))
+				break
+			}
+		}
+	}
+
+	v := &lintVar{name, kind, false, false}
+	scope.names[lexeme] = v
+	scope.order = append(scope.order, v)
+}
+
+// mark the closest declaration of a name as read
+func (l *linter) use(name Token) {
+	for idx := len(l.scopes) - 1; idx >= 0; idx-- {
+		if v, ok := l.scopes[idx].names[name.GetLexeme()]; ok {
+			v.used = true
+			return
+		}
+	}
+}
+
+// ----- AST walk
+
+// walk a statement list and flag code after a return
+func (l *linter) stmts(statements []Stmt) {
+	returned := false
+
+	for _, statement := range statements {
+		if statement == nil {
+			continue
+		}
+
+		if returned {
+			l.warn(statement.Line(), LintUnreachableCode, "unreachable code after return")
+			returned = false
+		}
+
+		l.stmt(statement)
+
+		if _, ok := statement.(*ReturnStmt); ok {
+			returned = true
+		}
+	}
+}
+
+func (l *linter) stmt(statement Stmt) {
+	switch s := statement.(type) {
+	case *ExprStmt:
+		l.expr(s.expression)
+	case *PrintStmt:
+		l.expr(s.expression)
+	case *AssertStmt:
+		l.expr(s.condition)
+		if s.message != nil {
+			l.expr(s.message)
+		}
+	case *BlockStmt:
+		l.beginScope()
+		l.stmts(s.statements)
+		l.endScope()
+	case *IfStmt:
+		l.expr(s.condition)
+		l.stmt(s.thenBranch)
+		if s.elseBranch != nil {
+			l.stmt(s.elseBranch)
+		}
+	case *WhileStmt:
+		l.expr(s.condition)
+		l.stmt(s.body)
+	case *ReturnStmt:
+		if s.value != nil {
+			l.expr(s.value)
+		}
+	case *FnStmt:
+		l.declare(s.name, "function")
+		scope := l.scopes[len(l.scopes)-1]
+		scope.functions = append(scope.functions, s)
+	case *VarStmt:
+		if s.initializer != nil {
+			l.expr(s.initializer)
+		}
+		l.declare(s.name, "variable")
+	}
+}
+
+func (l *linter) expr(expression Expr) {
+	switch x := expression.(type) {
+	case *VarExpr:
+		l.use(x.name)
+	case *AssignExpr:
+		l.expr(x.value)
+	case *UnaryExpr:
+		l.expr(x.right)
+	case *BinaryExpr:
+		l.expr(x.left)
+		l.expr(x.right)
+	case *LogicalExpr:
+		l.expr(x.left)
+		l.expr(x.right)
+	case *GroupingExpr:
+		l.expr(x.expression)
+	case *GetExpr:
+		l.expr(x.object)
+	case *CallExpr:
+		l.expr(x.callee)
+		for _, argument := range x.arguments {
+			l.expr(argument)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package almond
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLintRules(t *testing.T) {
+	cases := []struct {
+		name     string
+		src      string
+		disabled string
+		want     []string
+	}{
+		{"used variable", `var a = 1; print a;`, "", nil},
+		{"unused variable", "{\n var a = 1;\n}", "", []string{"2 unused-variable"}},
+		{"unused parameter is fine", `fn f(x) { return 1; } print f(2);`, "", nil},
+		{"shadowing", "var a = 1; print a;\n{ var a = 2; print a; }", "", []string{"2 shadowing"}},
+		{"shadowed parameter", "var n = 1; print n;\nfn f(n) { return n; } print f(2);", "", []string{"2 shadowing"}},
+		{"global declared after a function", `
+			fn total() {
+				var sum = 1;
+				return sum;
+			}
+			var sum = 2;
+			print sum + total();`, "", []string{"3 shadowing"}},
+		{"shadowed native", "var clock = 1; print clock;", "", []string{"1 shadowing"}},
+		{"shadowed prelude function", "fn min(a, b) { return a; }\nprint min(1, 2);", "", []string{"1 shadowing"}},
+		{"parameter shadowing a native", "fn f(readFile) { return readFile; } print f(1);", "", []string{"1 shadowing"}},
+		{"natives and prelude are read", `print abs(clock());`, "", nil},
+		{"global read by an earlier function", `
+			fn read() { return later; }
+			var later = 1;
+			print read();`, "", nil},
+		{"redeclaration", "var a = 1; print a;\nvar a = 2; print a;", "", []string{"2 redeclaration"}},
+		{"redeclared function", "fn f() {}\nfn f() {} f();", "", []string{"2 redeclaration"}},
+		{"unreachable code", "fn f() {\n return 1;\n print 2;\n}\nprint f();", "", []string{"3 unreachable-code"}},
+		{"unreachable in block", "fn f() { { return 1; print 2; } } print f();", "", []string{"1 unreachable-code"}},
+		{"sorted by line", "fn f() { var x = 1; }\n{ var y = 2; }\nf();", "", []string{"1 unused-variable", "2 unused-variable"}},
+		{"disabled rule", "{ var a = 1; }", LintUnusedVariable, nil},
+		{"other rule disabled", "{ var a = 1; }", LintShadowing, []string{"1 unused-variable"}},
+		{"ignore on the line", "{ var a = 1; # almond:ignore unused-variable\n}", "", nil},
+		{"ignore on the line before", "# almond:ignore unused-variable\n{ var a = 1; }", "", nil},
+		{"ignore only reaches the next line", "# almond:ignore unused-variable\n\n{ var a = 1; }", "", []string{"3 unused-variable"}},
+		{"ignore another rule", "# almond:ignore shadowing\n{ var a = 1; }", "", []string{"2 unused-variable"}},
+		{"ignore several rules", "var a = 1; print a;\n# almond:ignore unused-variable, shadowing\n{ var a = 2; }", "", nil},
+		{"ignore every rule", "# almond:ignore\n{ var a = 1; }", "", nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := LintOptions{Disabled: map[string]bool{c.disabled: true}}
+			diags, err := Lint(c.src, opts)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, diag := range diags {
+				got = append(got, fmt.Sprintf("%d %s", diag.Line, diag.Rule))
+			}
+
+			if strings.Join(got, ", ") != strings.Join(c.want, ", ") {
+				t.Errorf("got %v, want %v", diags, c.want)
+			}
+		})
+	}
+}
+
+// Natives and prelude globals have no line to point at
+func TestLintBuiltinShadowing(t *testing.T) {
+	diags, _ := Lint("fn max(a, b) { return a; }\nvar clock = max(1, 2);\nprint clock;", LintOptions{})
+
+	want := []string{
+		"[line 1] Warning (shadowing): function 'max' shadows a prelude function",
+		"[line 2] Warning (shadowing): variable 'clock' shadows a native",
+	}
+
+	if len(diags) != len(want) {
+		t.Fatalf("got %v", diags)
+	}
+
+	for idx, diag := range diags {
+		if diag.String() != want[idx] {
+			t.Errorf("got %s, want %s", diag.String(), want[idx])
+		}
+	}
+}
+
+// Syntax errors come back with the warnings of the parts that parsed
+func TestLintSyntaxError(t *testing.T) {
+	diags, err := Lint("{ var a = 1; }\nvar = ;", LintOptions{})
+
+	if err == nil {
+		t.Error("expected a syntax error")
+	}
+
+	if len(diags) != 1 || diags[0].String() != "[line 1] Warning (unused-variable): variable 'a' is never read" {
+		t.Errorf("got %v", diags)
+	}
+}
@@ -0,0 +1,104 @@
+package almond
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Go function exposed to scripts through reflection
+type NativeFunc struct {
+	name string
+	fn   reflect.Value
+}
+
+// Wrap a Go function, arguments and results are converted on every call
+func NewNativeFunc(name string, fn any) (*NativeFunc, error) {
+	value := reflect.ValueOf(fn)
+
+	if value.Kind() != reflect.Func || value.IsNil() {
+		return nil, fmt.Errorf("native %s: expected a function, got %T", name, fn)
+	}
+
+	fnType := value.Type()
+
+	switch fnType.NumOut() {
+	case 0, 1:
+	case 2:
+		if fnType.Out(1) != errorType {
+			return nil, fmt.Errorf("native %s: second result must be an error", name)
+		}
+	default:
+		return nil, fmt.Errorf("native %s: too many results", name)
+	}
+
+	return &NativeFunc{name, value}, nil
+}
+
+func (n *NativeFunc) Arity() int {
+	if n.fn.Type().IsVariadic() {
+		return -1
+	}
+	return n.fn.Type().NumIn()
+}
+
+func (n *NativeFunc) ToString() string {
+	return "<native fn " + n.name + ">"
+}
+
+func (n *NativeFunc) Call(env *Environment, args []Object) (result Object, err error) {
+	fnType := n.fn.Type()
+	fixed := fnType.NumIn()
+
+	if fnType.IsVariadic() {
+		fixed--
+
+		if len(args) < fixed {
+			return Object{}, fmt.Errorf("%s: expected at least %d arguments, but recieved %d", n.name, fixed, len(args))
+		}
+	}
+
+	in := make([]reflect.Value, len(args))
+
+	for idx, arg := range args {
+		var paramType reflect.Type
+
+		if idx >= fixed && fnType.IsVariadic() {
+			paramType = fnType.In(fixed).Elem()
+		} else {
+			paramType = fnType.In(idx)
+		}
+
+		in[idx], err = fromObject(arg, paramType)
+
+		if err != nil {
+			return Object{}, fmt.Errorf("%s: argument %d: %w", n.name, idx+1, err)
+		}
+	}
+
+	// a panicking native should not take the host down
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = Object{}, fmt.Errorf("%s: panic: %v", n.name, r)
+		}
+	}()
+
+	out := n.fn.Call(in)
+
+	if len(out) == 2 && !out[1].IsNil() {
+		return Object{}, fmt.Errorf("%s: %w", n.name, out[1].Interface().(error))
+	}
+
+	if len(out) == 0 {
+		return nullValue(), nil
+	}
+
+	// a lone error result
+	if fnType.Out(0) == errorType {
+		if !out[0].IsNil() {
+			return Object{}, fmt.Errorf("%s: %w", n.name, out[0].Interface().(error))
+		}
+		return nullValue(), nil
+	}
+
+	return toObject(out[0])
+}
@@ -0,0 +1,88 @@
+package almond
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNewNativeFuncRejectsBadSignatures(t *testing.T) {
+	var nilFunc func()
+
+	cases := map[string]any{
+		"not a function":   42,
+		"nil function":     nilFunc,
+		"second not error": func() (int, int) { return 0, 0 },
+		"too many results": func() (int, int, error) { return 0, 0, nil },
+	}
+
+	for name, fn := range cases {
+		if _, err := NewNativeFunc("f", fn); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+var errNativeFailed = errors.New("native failed")
+
+// Calls from scripts check arity and types and turn Go failures into runtime errors
+func TestNativeFuncCalls(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+
+	engine.RegisterFunc("add", func(a, b int) int { return a + b })
+	engine.RegisterFunc("join", func(sep string, parts ...string) string { return strings.Join(parts, sep) })
+	engine.RegisterFunc("fail", func() (int, error) { return 0, errNativeFailed })
+	engine.RegisterFunc("check", func(ok bool) error {
+		if !ok {
+			return errNativeFailed
+		}
+		return nil
+	})
+	engine.RegisterFunc("explode", func() { panic("kaboom") })
+
+	values := map[string]string{
+		`add(2, 3);`:           "5",
+		`join("-", "a", "b");`: "a-b",
+		`join(",");`:           "",
+		`check(true);`:         "NULL",
+	}
+
+	for src, want := range values {
+		value, err := engine.Eval(src)
+
+		if err != nil || value.String() != want {
+			t.Errorf("%s: got %s, %v", src, value.String(), err)
+		}
+	}
+
+	failures := map[string]string{
+		`add(1);`:       "expected 2 arguments, but recieved 1",
+		`add("1", 2);`:  "add: argument 1: expected int, got STRING",
+		`add(1, null);`: "add: argument 2: expected int, got NULL",
+		`join();`:       "join: expected at least 1 arguments, but recieved 0",
+		`join("-", 1);`: "join: argument 2: expected string, got NUMBER",
+		`fail();`:       "fail: native failed",
+		`check(false);`: "check: native failed",
+		`explode();`:    "explode: panic: kaboom",
+	}
+
+	for src, want := range failures {
+		_, err := engine.Eval(src)
+
+		var fault *RuntimeFault
+		if !errors.As(err, &fault) || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+
+	// errors returned by natives can be matched by the host
+	if _, err := engine.Eval(`fail();`); !errors.Is(err, errNativeFailed) {
+		t.Errorf("got %v, want errNativeFailed", err)
+	}
+
+	// the engine survives a panicking native
+	if value, err := engine.Eval(`add(1, 1);`); err != nil || value.String() != "2" {
+		t.Errorf("after panic: got %s, %v", value.String(), err)
+	}
+}
@@ -3,16 +3,81 @@ package almond
 import (
 	"fmt"
 	"os"
+	"reflect"
 	"strconv"
 )
 
+// Kind of a runtime value, separate from the token types of the lexer
+type valueKind uint8
+
+const (
+	// zero Object, held by slots whose declaration has not run
+	kindUnset valueKind = iota
+	kindNull
+	kindTrue
+	kindFalse
+	kindNumber
+	kindString
+	kindCallable
+	kindHost
+)
+
+// Token type a kind is reported as, error messages use its name
+var kindTokens = [...]TokenType{
+	kindUnset:    EOF,
+	kindNull:     NULL,
+	kindTrue:     TRUE,
+	kindFalse:    FALSE,
+	kindNumber:   NUMBER,
+	kindString:   STRING,
+	kindCallable: CALLABLE,
+	kindHost:     HOST,
+}
+
+// Runtime value: a tagged union where numbers live unboxed in num and
+// strings, callables and host values are held by ref
 type Object struct {
-	kind    TokenType
-	literal any
+	kind valueKind
+	num  float64
+	ref  any
+}
+
+// -- Ctors, values are built in place without allocating for numbers and constants
+func nullValue() Object {
+	return Object{kind: kindNull}
 }
 
-// Ctor
+func boolValue(b bool) Object {
+	if b {
+		return Object{kind: kindTrue}
+	}
+	return Object{kind: kindFalse}
+}
+
+func numberValue(f float64) Object {
+	return Object{kind: kindNumber, num: f}
+}
+
+func stringValue(s string) Object {
+	return Object{kind: kindString, ref: s}
+}
+
+func callableValue(c Callable) Object {
+	return Object{kind: kindCallable, ref: c}
+}
+
+func hostValue(v any) Object {
+	if v == nil {
+		fmt.Println("Implmentation Error: Created a host object and passed nil value.")
+		os.Exit(11)
+	}
+	return Object{kind: kindHost, ref: v}
+}
+
+// Ctor from a token type, for host code built against the token kinds
 func NewObject(k TokenType, v any) *Object {
+	var obj Object
+
 	switch k {
 	case STRING:
 		val, ok := v.(string)
@@ -20,7 +85,7 @@ func NewObject(k TokenType, v any) *Object {
 			fmt.Println("Implmentation Error: Created a string object and passed non-string value.")
 			os.Exit(1)
 		}
-		return &Object{k, val}
+		obj = stringValue(val)
 
 	case NUMBER:
 		val, ok := v.(float64)
@@ -28,7 +93,7 @@ func NewObject(k TokenType, v any) *Object {
 			fmt.Println("Implmentation Error: Created a number object and passed non-float64 value.")
 			os.Exit(2)
 		}
-		return &Object{k, val}
+		obj = numberValue(val)
 
 	case CALLABLE:
 		val, ok := v.(Callable)
@@ -38,47 +103,56 @@ func NewObject(k TokenType, v any) *Object {
 			fmt.Println("Implmentation Error: Created a function object and passed non-callable value.")
 			os.Exit(3)
 		}
-		return &Object{k, val}
+		obj = callableValue(val)
+
+	case HOST:
+		obj = hostValue(v)
+
+	case TRUE:
+		obj = boolValue(true)
+
+	case FALSE:
+		obj = boolValue(false)
 
 	default:
-		return &Object{k, nil}
+		obj = nullValue()
 	}
+	return &obj
 }
 
 // -- Truth value helpers
 func (o *Object) Bool() bool {
 	// 0, null, false
 	switch o.kind {
-	case NULL:
+	case kindNull, kindFalse:
 		return false
-	case FALSE:
-		return false
-	case NUMBER:
-		num, ok := o.literal.(float64)
-
-		if !ok {
-			fmt.Println("Implementation Error: Created an invalid number object. Found while evaluating truth value in Object.")
-		}
-
-		if num == 0 {
-			return false
-		}
+	case kindNumber:
+		return o.num != 0
 	}
 
 	// all other values are true
 	return true
 }
+
 func (left *Object) Equal(right *Object) bool {
 	if left.kind != right.kind {
 		return false
 	}
 
 	switch left.kind {
-	case NUMBER:
-	case STRING:
-		if right.literal != left.literal {
+	case kindNumber:
+		return left.num == right.num
+	case kindString:
+		return left.ref.(string) == right.ref.(string)
+	case kindHost:
+		// slices and maps cannot be compared with ==
+		lVal := reflect.ValueOf(left.ref)
+		rVal := reflect.ValueOf(right.ref)
+
+		if !lVal.Comparable() || !rVal.Comparable() {
 			return false
 		}
+		return lVal.Equal(rVal)
 	}
 
 	// otherwise if the kind matches (true, false, null etc)
@@ -87,48 +161,38 @@ func (left *Object) Equal(right *Object) bool {
 
 // -- Data retrieval helpers
 func (o *Object) GetKind() TokenType {
-	return o.kind
+	return kindTokens[o.kind]
 }
 
 func (o *Object) GetKindStr() string {
-	return o.kind.String()
+	return o.GetKind().String()
 }
 
+// Go value held by the object, nil for constants
 func (o *Object) GetLiteral() any {
-	return o.literal
+	if o.kind == kindNumber {
+		return o.num
+	}
+	return o.ref
 }
 
 // -- Formatting helpers
 func (o *Object) String() string {
 	switch o.kind {
-	case STRING:
-		s, ok := o.literal.(string)
+	case kindString:
+		return o.ref.(string)
 
-		if !ok {
-			fmt.Println("Implementation Error: failed to extract string from type String Object.")
-			os.Exit(8)
-		}
-		return s
-	case NUMBER:
-		f, ok := o.literal.(float64)
+	case kindNumber:
+		return strconv.FormatFloat(o.num, 'f', -1, 64)
 
-		if !ok {
-			fmt.Println("Implementation Error: failed to extract number from type Number Object.")
-			os.Exit(9)
-		}
-		return strconv.FormatFloat(f, 'f', -1, 64)
-
-	case CALLABLE:
-		f, ok := o.literal.(Callable)
+	case kindCallable:
+		return o.ref.(Callable).ToString()
 
-		if !ok {
-			fmt.Println("Implementation Error: failed to get Callable from function")
-			os.Exit(10)
-		}
-		return f.ToString()
+	case kindHost:
+		return fmt.Sprint(o.ref)
 
 	default:
-		return o.kind.String()
+		return o.GetKindStr()
 	}
 }
 
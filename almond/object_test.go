@@ -0,0 +1,46 @@
+package almond
+
+import "testing"
+
+// Numbers and constants are held unboxed, so arithmetic must not allocate
+func TestArithmeticDoesNotAllocate(t *testing.T) {
+	plus := *NewToken(PLUS, "+", "", 1)
+	less := *NewToken(LESS, "<", "", 1)
+	minus := *NewToken(MINUS, "-", "", 1)
+	left, right := numberValue(3), numberValue(4)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		sum, _ := binaryOp(nil, plus, left, right)
+		cmp, _ := binaryOp(nil, less, sum, right)
+		neg, _ := unaryOp(minus, sum)
+
+		if sum.num != 7 || cmp.kind != kindFalse || neg.num != -7 {
+			t.Fatal("wrong result")
+		}
+	})
+
+	if allocs != 0 {
+		t.Errorf("arithmetic allocated %v times per run", allocs)
+	}
+}
+
+func TestObjectKindsKeepTokenNames(t *testing.T) {
+	values := map[string]Object{
+		"NULL":     nullValue(),
+		"TRUE":     boolValue(true),
+		"FALSE":    boolValue(false),
+		"NUMBER":   numberValue(1),
+		"STRING":   stringValue("s"),
+		"CALLABLE": callableValue(NewNativeClock()),
+	}
+
+	for want, value := range values {
+		if got := value.GetKindStr(); got != want {
+			t.Errorf("got kind %s, want %s", got, want)
+		}
+
+		if compat := NewObject(value.GetKind(), value.GetLiteral()); !compat.Equal(&value) {
+			t.Errorf("NewObject(%s) does not round trip", want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package almond
+
+import "math"
+
+// Rewrite statements before they run: fold constant expressions, drop
+// branches and loops that can never run and remove double negation in
+// conditions. Changed nodes are copied so parsed statements stay shared.
+// Anything that would raise a runtime error is left alone so the error
+// still happens at its original line.
+func optimize(statements []Stmt) []Stmt {
+	out := make([]Stmt, len(statements))
+
+	for idx, statement := range statements {
+		out[idx] = optimizeStmt(statement)
+	}
+	return out
+}
+
+// Statement that does nothing, keeps top level results and branches valid
+func emptyStmt(line int) Stmt {
+	return &BlockStmt{line, nil, &frameInfo{}}
+}
+
+// A branch replacing its if statement, a bare expression statement would
+// become the result of a script
+func branchStmt(statement Stmt) Stmt {
+	if x, ok := statement.(*ExprStmt); ok {
+		return &BlockStmt{x.line, []Stmt{x}, &frameInfo{}}
+	}
+	return statement
+}
+
+func optimizeStmt(statement Stmt) Stmt {
+	switch s := statement.(type) {
+	case *ExprStmt:
+		return NewExprStmt(s.line, optimizeExpr(s.expression))
+
+	case *PrintStmt:
+		return NewPrintStmt(s.keyword, optimizeExpr(s.expression))
+
+	case *AssertStmt:
+		// the condition is shown as written when the assertion fails
+		if s.message == nil {
+			return s
+		}
+		return NewAssertStmt(s.keyword, s.condition, optimizeExpr(s.message))
+
+	case *BlockStmt:
+		return &BlockStmt{s.line, optimize(s.statements), s.frame}
+
+	case *IfStmt:
+		condition := optimizeCondition(s.condition)
+
+		if value, ok := constant(condition); ok {
+			switch {
+			case value.Bool():
+				return branchStmt(optimizeStmt(s.thenBranch))
+			case s.elseBranch != nil:
+				return branchStmt(optimizeStmt(s.elseBranch))
+			}
+			return emptyStmt(s.Line())
+		}
+
+		var elseBranch Stmt
+		if s.elseBranch != nil {
+			elseBranch = optimizeStmt(s.elseBranch)
+		}
+		return NewIfStmt(s.keyword, condition, optimizeStmt(s.thenBranch), elseBranch)
+
+	case *WhileStmt:
+		condition := optimizeCondition(s.condition)
+
+		if value, ok := constant(condition); ok && !value.Bool() {
+			return emptyStmt(s.Line())
+		}
+		return NewWhileStmt(s.keyword, condition, optimizeStmt(s.body))
+
+	case *ReturnStmt:
+		if s.value == nil {
+			return s
+		}
+		// calls are never folded, so a tail call stays one
+		return &ReturnStmt{s.keyword, optimizeExpr(s.value), s.tail}
+
+	case *FnStmt:
+		fn := *s
+		fn.body = optimize(s.body)
+		return &fn
+
+	case *VarStmt:
+		if s.initializer == nil {
+			return s
+		}
+		return &VarStmt{s.name, optimizeExpr(s.initializer), s.slot}
+	}
+	return statement
+}
+
+// Value of a literal, seen through groupings
+func constant(expression Expr) (Object, bool) {
+	switch x := expression.(type) {
+	case *Literal:
+		return x.value, true
+	case *GroupingExpr:
+		return constant(x.expression)
+	}
+	return Object{}, false
+}
+
+// Optimize an expression whose value is only tested for truth
+func optimizeCondition(expression Expr) Expr {
+	return truthOf(optimizeExpr(expression))
+}
+
+// Drop double negation from an optimized expression tested for truth
+func truthOf(expression Expr) Expr {
+	switch x := expression.(type) {
+	case *UnaryExpr:
+		// !!x has the truth of x
+		if inner, ok := x.right.(*UnaryExpr); ok && x.operator.GetType() == BANG && inner.operator.GetType() == BANG {
+			return truthOf(inner.right)
+		}
+	case *GroupingExpr:
+		return NewGroupingExpr(truthOf(x.expression))
+	case *LogicalExpr:
+		// the result is one of the operands, so only their truth matters
+		return NewLogicalExpr(truthOf(x.left), x.operator, truthOf(x.right))
+	}
+	return expression
+}
+
+func optimizeExpr(expression Expr) Expr {
+	switch x := expression.(type) {
+	case *GroupingExpr:
+		inner := optimizeExpr(x.expression)
+
+		if value, ok := constant(inner); ok {
+			return &Literal{value}
+		}
+		return NewGroupingExpr(inner)
+
+	case *UnaryExpr:
+		right := optimizeExpr(x.right)
+
+		// the operand of ! is only tested for truth
+		if x.operator.GetType() == BANG {
+			right = truthOf(right)
+		}
+
+		if value, ok := constant(right); ok {
+			if folded, ok := fold(func() (Object, error) { return unaryOp(x.operator, value) }); ok {
+				return folded
+			}
+		}
+		return NewUnaryExpr(x.operator, right)
+
+	case *BinaryExpr:
+		left := optimizeExpr(x.left)
+		right := optimizeExpr(x.right)
+
+		lValue, lOk := constant(left)
+		rValue, rOk := constant(right)
+
+		// concatenation allocates and counts against the memory limit at runtime
+		concat := x.operator.GetType() == PLUS && (lValue.kind == kindString || rValue.kind == kindString)
+
+		if lOk && rOk && !concat {
+			if folded, ok := fold(func() (Object, error) { return binaryOp(nil, x.operator, lValue, rValue) }); ok {
+				return folded
+			}
+		}
+		return NewBinaryExpr(left, x.operator, right)
+
+	case *AssignExpr:
+		return &AssignExpr{x.name, optimizeExpr(x.value), x.depth, x.slot}
+
+	case *LogicalExpr:
+		return NewLogicalExpr(optimizeExpr(x.left), x.operator, optimizeExpr(x.right))
+
+	case *CallExpr:
+		arguments := make([]Expr, len(x.arguments))
+		for idx, argument := range x.arguments {
+			arguments[idx] = optimizeExpr(argument)
+		}
+		return NewCallExpr(optimizeExpr(x.callee), x.paren, arguments)
+
+	case *GetExpr:
+		return NewGetExpr(optimizeExpr(x.object), x.name)
+	}
+	return expression
+}
+
+// Literal for an operation that succeeds, errors and values without
+// a source form such as NaN are left for runtime
+func fold(apply func() (Object, error)) (Expr, bool) {
+	value, err := apply()
+
+	if err != nil {
+		return nil, false
+	}
+
+	if value.kind == kindNumber && (math.IsNaN(value.num) || math.IsInf(value.num, 0)) {
+		return nil, false
+	}
+	return &Literal{value}, true
+}
@@ -0,0 +1,86 @@
+package almond
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestOptimizeFoldsConstants(t *testing.T) {
+	statements, _ := parseSource(`var day = 60 * 60 * 24; print -(2 - 5) + (1 < 2 == true);`)
+	out := optimize(statements)
+
+	day := out[0].(*VarStmt).initializer
+	if lit, ok := day.(*Literal); !ok || lit.value.String() != "86400" {
+		t.Errorf("var day = %s", exprSource(day))
+	}
+
+	// folding stops where a mismatch must be raised at runtime
+	if got := exprSource(out[1].(*PrintStmt).expression); got != "3 + true" {
+		t.Errorf("print %s", got)
+	}
+
+	// the parsed statements are left untouched
+	if got := exprSource(statements[0].(*VarStmt).initializer); got != "60 * 60 * 24" {
+		t.Errorf("parsed statement changed to %s", got)
+	}
+}
+
+func TestOptimizeDropsDeadCode(t *testing.T) {
+	statements, _ := parseSource(`
+		if (false) { print "never"; }
+		if (0) print "zero"; else print "else";
+		while (false) print "loop";
+		while (!!x) print x;`)
+	out := optimize(statements)
+
+	want := []string{"{\n}", `print "else";`, "{\n}", "while (x)\n    print x;"}
+
+	for idx, statement := range out {
+		if got := stmtSource(statement, ""); got != want[idx] {
+			t.Errorf("statement %d: got %q, want %q", idx, got, want[idx])
+		}
+	}
+}
+
+// Optimized scripts must print, return and fail exactly like unoptimized ones
+func TestOptimizePreservesBehavior(t *testing.T) {
+	scripts := map[string]string{
+		"mismatch line": "var a = 1 + 2;\nprint a;\nprint 2 * (\"x\" - 1);",
+		"unary error":   "print 1;\nprint -\"a\";",
+		"assert":        "assert 60 * 60 == 3601, 1 + 1;",
+		"result":        "1 + 2; if (true) 3 * 3;",
+		"chosen branch": "if (1 == 1) { var x = 2 * 2; print x; } else print 0;",
+		"conditions":    "var x = 3; if (!!x & !!(x - 3 | \"s\")) print \"both\"; print !!x;",
+		"division":      "print 1 / 0; print 0 / 0 == 0 / 0;",
+		"negative zero": "print -0 == 0; print 1 / -0;",
+	}
+
+	for name, src := range scripts {
+		for _, treeWalk := range []bool{false, true} {
+			optimized := runOptimized(t, src, treeWalk, true)
+			plain := runOptimized(t, src, treeWalk, false)
+
+			if optimized != plain {
+				t.Errorf("%s (tree-walk %v): optimized\n%s\nplain\n%s", name, treeWalk, optimized, plain)
+			}
+		}
+	}
+}
+
+func runOptimized(t *testing.T, src string, treeWalk, optimized bool) string {
+	var stdout bytes.Buffer
+	engine, err := NewEngine(Options{Stdout: &stdout, Stderr: io.Discard, TreeWalk: treeWalk, NoOptimize: !optimized})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := engine.Eval(src)
+	out := stdout.String() + "value: " + value.String()
+
+	if err != nil {
+		out += "\nerror: " + err.Error()
+	}
+	return out
+}
@@ -2,21 +2,64 @@ package almond
 
 import (
 	"errors"
-	"fmt"
+	"io"
+	"os"
 )
 
+// Tokens pulled by the parser, EOF is returned once the source ends
+type TokenSource interface {
+	Next() Token
+}
+
+// Source over a token list, such as the result of Tokenize
+type tokenList struct {
+	tokens []Token
+	line   int
+}
+
+func (t *tokenList) Next() Token {
+	if len(t.tokens) == 0 {
+		return *NewToken(EOF, "", "", t.line)
+	}
+
+	tok := t.tokens[0]
+	t.tokens = t.tokens[1:]
+	t.line = tok.GetLine()
+	return tok
+}
+
 // PARSER DESCRIPTION
 type Parser struct {
-	tokens  []Token
-	current int
+	source TokenSource
+	// one token lookahead, pulled from the source when first needed
+	next   Token
+	pulled bool
+	last   Token
+	out    io.Writer
+	faults []error
 }
 
 // Ctor
 func NewParser(tokens []Token) *Parser {
-	thisParser := Parser{tokens, 0}
+	return NewStreamParser(&tokenList{tokens, 1})
+}
+
+// Ctor for a parser pulling tokens as it goes, such as from a Tokenizer
+func NewStreamParser(source TokenSource) *Parser {
+	thisParser := Parser{source, Token{}, false, Token{}, os.Stdout, nil}
 	return &thisParser
 }
 
+// Set where errors are printed
+func (p *Parser) SetOutput(out io.Writer) {
+	p.out = out
+}
+
+// Errors found while parsing
+func (p *Parser) Faults() []error {
+	return p.faults
+}
+
 // RECURSIVE DESCENT
 
 // ------ Entry
@@ -27,6 +70,9 @@ func (p *Parser) Parse() []Stmt {
 		statements = append(statements, p.declarationStmt())
 	}
 
+	// bind locals to slots before anything runs
+	resolve(statements)
+
 	return statements
 }
 
@@ -34,6 +80,7 @@ func (p *Parser) Parse() []Stmt {
 
 // get the expression from statment
 func (p *Parser) expressionStmt() (Stmt, error) {
+	line := p.peek().GetLine()
 	value, err := p.expression()
 
 	if err != nil {
@@ -45,7 +92,7 @@ func (p *Parser) expressionStmt() (Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	return *NewExprStmt(value), nil
+	return NewExprStmt(line, value), nil
 }
 
 // evaluate block or scoped statement
@@ -66,6 +113,7 @@ func (p *Parser) blockStmt() ([]Stmt, error) {
 
 // evaluate print statment
 func (p *Parser) printStmt() (Stmt, error) {
+	keyword := p.previous()
 	value, err := p.expression()
 
 	if err != nil {
@@ -73,7 +121,35 @@ func (p *Parser) printStmt() (Stmt, error) {
 	}
 
 	p.consume(SEMI_COLON, "Expect ';' after value.")
-	return *NewPrintStmt(value), nil
+	return NewPrintStmt(keyword, value), nil
+}
+
+// evaluate assert statement with optional message
+func (p *Parser) assertStmt() (Stmt, error) {
+	keyword := p.previous()
+	condition, err := p.expression()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var message Expr = nil
+
+	if p.match(COMMA) {
+		message, err = p.expression()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = p.consume(SEMI_COLON, "Expect ';' after assert.")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAssertStmt(keyword, condition, message), nil
 }
 
 func (p *Parser) returnStmt() (Stmt, error) {
@@ -95,11 +171,12 @@ func (p *Parser) returnStmt() (Stmt, error) {
 		return nil, err
 	}
 
-	return NewReturnStmt(*keyword, value), nil
+	return NewReturnStmt(keyword, value), nil
 }
 
 // evaluate whole statment
 func (p *Parser) whileStmt() (Stmt, error) {
+	keyword := p.previous()
 	_, err := p.consume(L_PAREN, "Expect '(' after while.")
 
 	if err != nil {
@@ -124,12 +201,13 @@ func (p *Parser) whileStmt() (Stmt, error) {
 		return nil, err
 	}
 
-	return NewWhileStmt(condition, body), nil
+	return NewWhileStmt(keyword, condition, body), nil
 
 }
 
 // evaluate for statement
 func (p *Parser) forStmt() (Stmt, error) {
+	keyword := p.previous()
 	_, err := p.consume(L_PAREN, "Expect '(' after 'for'.")
 
 	if err != nil {
@@ -191,17 +269,17 @@ func (p *Parser) forStmt() (Stmt, error) {
 	}
 
 	if increment != nil {
-		body = NewBlockStmt([]Stmt{body, NewExprStmt(increment)})
+		body = NewBlockStmt(keyword.GetLine(), []Stmt{body, NewExprStmt(keyword.GetLine(), increment)})
 	}
 
 	if condition == nil {
 		condition = NewLiteral(TRUE)
 	}
 
-	body = NewWhileStmt(condition, body)
+	body = NewWhileStmt(keyword, condition, body)
 
 	if initializer != nil {
-		body = NewBlockStmt([]Stmt{initializer, body})
+		body = NewBlockStmt(keyword.GetLine(), []Stmt{initializer, body})
 	}
 
 	return body, nil
@@ -209,6 +287,7 @@ func (p *Parser) forStmt() (Stmt, error) {
 
 // evaluate if statement
 func (p *Parser) ifStmt() (Stmt, error) {
+	keyword := p.previous()
 	p.consume(L_PAREN, "Expected '(' after 'if'")
 
 	condition, err := p.expression()
@@ -236,7 +315,7 @@ func (p *Parser) ifStmt() (Stmt, error) {
 		}
 	}
 
-	return *NewIfStmt(condition, thenBranch, elseBranch), nil
+	return NewIfStmt(keyword, condition, thenBranch, elseBranch), nil
 }
 
 // direct to correct statement
@@ -256,15 +335,19 @@ func (p *Parser) statement() (Stmt, error) {
 	if p.match(PRINT) {
 		return p.printStmt()
 	}
+	if p.match(ASSERT) {
+		return p.assertStmt()
+	}
 
 	if p.match(L_BRACE) {
+		brace := p.previous()
 		value, err := p.blockStmt()
 
 		if err != nil {
 			return nil, err
 		}
 
-		return NewBlockStmt(value), nil
+		return NewBlockStmt(brace.GetLine(), value), nil
 	}
 	return p.expressionStmt()
 
@@ -292,7 +375,7 @@ func (p *Parser) fnStmt(kind string) (Stmt, error) {
 	if !p.check(R_PAREN) {
 		for ok := true; ok; ok = p.match(COMMA) {
 			if len(params) >= 255 {
-				TokenError(*p.peek(), "cannot have more than 255 args")
+				p.tokenError(p.peek(), "cannot have more than 255 args")
 			}
 
 			param, err := p.consume(IDENTIFIER, "expected parameter name")
@@ -301,7 +384,7 @@ func (p *Parser) fnStmt(kind string) (Stmt, error) {
 				return nil, err
 			}
 
-			params = append(params, *param)
+			params = append(params, param)
 		}
 	}
 
@@ -323,7 +406,7 @@ func (p *Parser) fnStmt(kind string) (Stmt, error) {
 		return nil, err
 	}
 
-	return *NewFnStmt(*name, params, body), nil
+	return NewFnStmt(name, params, body), nil
 }
 
 // assign value to identifier
@@ -350,7 +433,7 @@ func (p *Parser) varStmt() (Stmt, error) {
 		return nil, err
 	}
 
-	return NewVarStmt(*name, initializer), nil
+	return NewVarStmt(name, initializer), nil
 
 }
 
@@ -392,7 +475,7 @@ func (p *Parser) primaryExpr() (Expr, error) {
 			value, ok := tok.GetLiteral().(float64)
 
 			if !ok {
-				return Literal{}, nil
+				return &Literal{}, nil
 			}
 
 			return NewNumber(value), nil
@@ -403,7 +486,7 @@ func (p *Parser) primaryExpr() (Expr, error) {
 
 	// Get Identifier
 	if p.match(IDENTIFIER) {
-		return NewVarExpr(*p.previous()), nil
+		return NewVarExpr(p.previous()), nil
 	}
 
 	// check parenthesis
@@ -427,7 +510,7 @@ func (p *Parser) primaryExpr() (Expr, error) {
 	}
 
 	// unknown character
-	return NewLiteral(p.peek().GetType()), errors.New("Parser Error: unknown literal in primary")
+	return NewLiteral(p.peek().GetType()), p.tokenError(p.peek(), "Expect expression.")
 }
 
 // helper function to deal with calls
@@ -444,7 +527,7 @@ func (p *Parser) finishCall(callee Expr) (Expr, error) {
 
 			// limit max arguments
 			if len(arguments) >= 255 {
-				return nil, errors.New("cannot exceed more than 255 arguments")
+				return nil, p.tokenError(p.peek(), "cannot exceed more than 255 arguments")
 			}
 
 			arguments = append(arguments, expr)
@@ -457,7 +540,7 @@ func (p *Parser) finishCall(callee Expr) (Expr, error) {
 		return nil, err
 	}
 
-	return NewCallExpr(callee, *paren, arguments), nil
+	return NewCallExpr(callee, paren, arguments), nil
 }
 
 // evaluates to a call expression
@@ -477,6 +560,15 @@ func (p *Parser) callExpr() (Expr, error) {
 			if err != nil {
 				return expr, err
 			}
+		} else if p.match(PERIOD) {
+			// property access
+			name, err := p.consume(IDENTIFIER, "Expect property name after '.'.")
+
+			if err != nil {
+				return expr, err
+			}
+
+			expr = NewGetExpr(expr, name)
 		} else {
 			break
 		}
@@ -496,7 +588,7 @@ func (p *Parser) unaryExpr() (Expr, error) {
 			return nil, err
 		}
 
-		return NewUnaryExpr(*operator, right), nil
+		return NewUnaryExpr(operator, right), nil
 	}
 
 	return p.callExpr()
@@ -518,7 +610,7 @@ func (p *Parser) factorExpr() (Expr, error) {
 			return nil, err
 		}
 
-		expression = NewBinaryExpr(expression, *operator, right)
+		expression = NewBinaryExpr(expression, operator, right)
 	}
 
 	return expression, nil
@@ -542,7 +634,7 @@ func (p *Parser) termExpr() (Expr, error) {
 			return nil, err
 		}
 
-		expression = NewBinaryExpr(expression, *operator, right)
+		expression = NewBinaryExpr(expression, operator, right)
 	}
 
 	return expression, nil
@@ -566,7 +658,7 @@ func (p *Parser) comparisonExpr() (Expr, error) {
 			return nil, err
 		}
 
-		expression = NewBinaryExpr(expression, *operator, right)
+		expression = NewBinaryExpr(expression, operator, right)
 	}
 
 	return expression, nil
@@ -590,7 +682,7 @@ func (p *Parser) equalityExpr() (Expr, error) {
 			return nil, err
 		}
 
-		expression = NewBinaryExpr(expression, *operator, right)
+		expression = NewBinaryExpr(expression, operator, right)
 	}
 
 	// no errors
@@ -614,7 +706,7 @@ func (p *Parser) andExpr() (Expr, error) {
 			return nil, err
 		}
 
-		express = NewLogicalExpr(express, *operator, right)
+		express = NewLogicalExpr(express, operator, right)
 	}
 
 	return express, nil
@@ -638,7 +730,7 @@ func (p *Parser) orExpr() (Expr, error) {
 			return nil, err
 		}
 
-		express = NewLogicalExpr(express, *operator, right)
+		express = NewLogicalExpr(express, operator, right)
 	}
 	return express, nil
 }
@@ -662,9 +754,8 @@ func (p *Parser) assignmentExpr() (Expr, error) {
 		s, ok := express.(*VarExpr)
 
 		if !ok {
-			TokenError(*equals, "Invalid assignment target.")
-			fmt.Printf("Dynamic type: %T\n", express)
-			return Literal{}, errors.New("invalid assignment target")
+			p.tokenError(equals, "Invalid assignment target.")
+			return &Literal{}, errors.New("invalid assignment target")
 		}
 
 		name := s.GetToken()
@@ -684,16 +775,14 @@ func (p *Parser) expression() (Expr, error) {
 
 // checks if last token is reached
 func (p *Parser) isAtEnd() bool {
-	return p.peek().GetType() == EOF
+	return p.peekType() == EOF
 }
 
 // match current token with supplied token
 func (p *Parser) check(tokType TokenType) bool {
-	if p.isAtEnd() {
-		return false
-	}
+	current := p.peekType()
 
-	return p.peek().GetType() == tokType
+	return current != EOF && current == tokType
 }
 
 // match and advance tokens
@@ -711,34 +800,55 @@ func (p *Parser) match(tokTypes ...TokenType) bool {
 // ----- Token helpers
 
 // check current token
-func (p *Parser) peek() *Token {
-	return &p.tokens[p.current]
+func (p *Parser) peek() Token {
+	p.pull()
+	return p.next
+}
+
+// type of the current token, without copying the token
+func (p *Parser) peekType() TokenType {
+	p.pull()
+	return p.next.kind
 }
 
 // check previous token
-func (p *Parser) previous() *Token {
-	return &p.tokens[p.current-1]
+func (p *Parser) previous() Token {
+	return p.last
 }
 
 // return current token and go to next
-func (p *Parser) advance() *Token {
+func (p *Parser) advance() Token {
 	if !p.isAtEnd() {
-		p.current++
+		p.last = p.next
+		p.pulled = false
 	}
 	return p.previous()
 }
 
+// fill the lookahead from the source
+func (p *Parser) pull() {
+	if !p.pulled {
+		p.next = p.source.Next()
+		p.pulled = true
+	}
+}
+
 // ----- Token + Error helpers
 
 // return current token, advance and report error
-func (p *Parser) consume(tokType TokenType, message string) (*Token, error) {
+func (p *Parser) consume(tokType TokenType, message string) (Token, error) {
 	if p.check(tokType) {
 		return p.advance(), nil
 	}
 
-	TokenError(*p.peek(), message)
+	return Token{}, p.tokenError(p.peek(), message)
+}
 
-	return &Token{}, errors.New("Parser Error: issue occured while parsing token: " + tokType.String())
+// report an error at a token
+func (p *Parser) tokenError(tok Token, message string) error {
+	fault := report(p.out, tok.GetLine(), tokenWhere(tok), message)
+	p.faults = append(p.faults, fault)
+	return fault
 }
 
 // ----- Error helpers
@@ -0,0 +1,114 @@
+package almond
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+)
+
+//go:embed prelude/*.al
+var preludeFiles embed.FS
+
+// Almond source run into the base scope of new interpreters.
+// It is parsed once and shared by every interpreter that loads it.
+type Prelude struct {
+	statements []Stmt
+
+	// bytecode, compiled on first load
+	chunk *Chunk
+	once  sync.Once
+}
+
+var (
+	defaultPrelude     *Prelude
+	defaultPreludeErr  error
+	defaultPreludeOnce sync.Once
+)
+
+// Prelude built from the sources embedded in the binary, an error means
+// the binary was built with a broken prelude
+func DefaultPrelude() (*Prelude, error) {
+	defaultPreludeOnce.Do(func() {
+		entries, err := preludeFiles.ReadDir("prelude")
+
+		if err != nil {
+			defaultPreludeErr = fmt.Errorf("%w: reading embedded prelude: %w", ErrInternal, err)
+			return
+		}
+
+		// load files in a stable order
+		sort.Slice(entries, func(a, b int) bool { return entries[a].Name() < entries[b].Name() })
+
+		var statements []Stmt
+
+		for _, entry := range entries {
+			data, _ := preludeFiles.ReadFile(path.Join("prelude", entry.Name()))
+			prelude, err := NewPrelude(string(data))
+
+			if err != nil {
+				defaultPreludeErr = fmt.Errorf("%w: embedded prelude %s: %w", ErrInternal, entry.Name(), err)
+				return
+			}
+			statements = append(statements, prelude.statements...)
+		}
+
+		defaultPrelude = &Prelude{statements: statements}
+	})
+	return defaultPrelude, defaultPreludeErr
+}
+
+// Parse a host supplied prelude, it is optimized once here
+func NewPrelude(src string) (*Prelude, error) {
+	statements, err := parseSource(src)
+
+	if err != nil {
+		return nil, err
+	}
+	return &Prelude{statements: optimize(statements)}, nil
+}
+
+// Parse without printing, syntax errors are returned
+func parseSource(src string) ([]Stmt, error) {
+	tokenizer := NewTokenizer(src)
+	tokenizer.SetOutput(io.Discard)
+	parser := NewStreamParser(tokenizer)
+	parser.SetOutput(io.Discard)
+	statements := parser.Parse()
+
+	faults := append(tokenizer.Faults(), parser.Faults()...)
+
+	if len(faults) > 0 {
+		return nil, errors.Join(faults...)
+	}
+	return statements, nil
+}
+
+// Run the prelude into the base scope of an interpreter
+func (p *Prelude) load(inter *Interpreter) error {
+	defer inter.begin(context.Background())()
+
+	base := inter.env.enclosing
+
+	if !inter.walking() {
+		p.once.Do(func() { p.chunk, _ = compile(p.statements) })
+
+		if p.chunk != nil {
+			_, err := inter.runChunk(p.chunk, base)
+			return err
+		}
+	}
+
+	for _, statement := range p.statements {
+		err := inter.execute(statement, base)
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+# Numeric helpers available to every script
+
+fn abs(x) {
+    if (x < 0) return -x;
+    return x;
+}
+
+fn min(a, b) {
+    if (a < b) return a;
+    return b;
+}
+
+fn max(a, b) {
+    if (a > b) return a;
+    return b;
+}
+
+fn clamp(x, lo, hi) {
+    return min(max(x, lo), hi);
+}
+
+# x raised to a whole number power
+fn pow(x, n) {
+    var result = 1;
+    for (var i = 0; i < abs(n); i = i + 1) {
+        result = result * x;
+    }
+    if (n < 0) return 1 / result;
+    return result;
+}
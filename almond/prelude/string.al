@@ -0,0 +1,10 @@
+# String helpers available to every script
+
+# s repeated n times
+fn repeat(s, n) {
+    var result = "";
+    for (var i = 0; i < n; i = i + 1) {
+        result = result + s;
+    }
+    return result;
+}
@@ -0,0 +1,126 @@
+package almond
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDefaultPrelude(t *testing.T) {
+	values := map[string]string{
+		`abs(-3);`:         "3",
+		`min(2, 5);`:       "2",
+		`max(2, 5);`:       "5",
+		`clamp(9, 0, 4);`:  "4",
+		`pow(2, 10);`:      "1024",
+		`pow(2, -1);`:      "0.5",
+		`repeat("ab", 3);`: "ababab",
+		`repeat("ab", 0);`: "",
+	}
+
+	for _, treeWalk := range []bool{false, true} {
+		engine, err := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, TreeWalk: treeWalk})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for src, want := range values {
+			if value, err := engine.Eval(src); err != nil || value.String() != want {
+				t.Errorf("tree %v, %s: got %s, %v", treeWalk, src, value.String(), err)
+			}
+		}
+	}
+
+	// scripts may shadow prelude functions with their own
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+	value, err := engine.Eval(`fn abs(x) { return 0; } abs(-3);`)
+
+	if err != nil || value.String() != "0" {
+		t.Errorf("shadowed: got %s, %v", value.String(), err)
+	}
+}
+
+func TestNoPrelude(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, NoPrelude: true, Prelude: mustPrelude(t, `fn abs(x) { return x; }`)})
+
+	if _, ok := engine.GetGlobal("abs"); ok {
+		t.Error("abs is defined")
+	}
+
+	if _, err := engine.Eval(`abs(-1);`); err == nil || !strings.Contains(err.Error(), "Undefined variable 'abs'") {
+		t.Errorf("got %v", err)
+	}
+}
+
+// A custom prelude replaces the embedded one and runs under the engine's options
+func TestCustomPrelude(t *testing.T) {
+	var stdout bytes.Buffer
+	prelude := mustPrelude(t, `var greeting = "hi"; fn greet(name) { return greeting + " " + name; } print "loaded";`)
+
+	for _, treeWalk := range []bool{false, true} {
+		stdout.Reset()
+		engine, err := NewEngine(Options{Stdout: &stdout, Stderr: io.Discard, Prelude: prelude, TreeWalk: treeWalk})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stdout.String() != "loaded\n" {
+			t.Errorf("tree %v: prelude printed %q", treeWalk, stdout.String())
+		}
+
+		if value, err := engine.Eval(`greet("bob");`); err != nil || value.String() != "hi bob" {
+			t.Errorf("tree %v: got %s, %v", treeWalk, value.String(), err)
+		}
+
+		if _, ok := engine.GetGlobal("abs"); ok {
+			t.Errorf("tree %v: embedded prelude was loaded too", treeWalk)
+		}
+
+		// script globals shadow the prelude's without replacing them
+		value, err := engine.Eval(`var greeting = "yo"; greet("al");`)
+
+		if err != nil || value.String() != "hi al" {
+			t.Errorf("tree %v: shadowed global: got %s, %v", treeWalk, value.String(), err)
+		}
+	}
+}
+
+func TestBadPrelude(t *testing.T) {
+	if _, err := NewPrelude(`fn broken( {`); err == nil {
+		t.Error("syntax error was accepted")
+	}
+
+	prelude := mustPrelude(t, `fn fail() { return missing; } fail();`)
+
+	engine, err := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Prelude: prelude})
+
+	if err == nil || engine != nil || !strings.Contains(err.Error(), "Undefined variable 'missing'") {
+		t.Errorf("engine: got %v, %v", engine, err)
+	}
+
+	inter, err := NewInterpreterWith(DefaultCapabilities, prelude)
+
+	if err == nil || inter != nil {
+		t.Errorf("interpreter: got %v, %v", inter, err)
+	}
+
+	// the prelude runs with the engine's capabilities
+	prelude = mustPrelude(t, `var home = getEnv("HOME");`)
+
+	if _, err := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Prelude: prelude}); err == nil || !strings.Contains(err.Error(), ErrCapability.Error()) {
+		t.Errorf("denied native: got %v", err)
+	}
+}
+
+func mustPrelude(t *testing.T, src string) *Prelude {
+	t.Helper()
+	prelude, err := NewPrelude(src)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prelude
+}
@@ -0,0 +1,123 @@
+package almond
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Render an expression back to almond source
+func exprSource(expression Expr) string {
+	switch x := expression.(type) {
+	case *Literal:
+		switch x.value.GetKind() {
+		case TRUE:
+			return "true"
+		case FALSE:
+			return "false"
+		case NULL:
+			return "null"
+		case STRING:
+			// almond strings have no escapes
+			return "\"" + x.value.String() + "\""
+		}
+		return x.value.String()
+	case *VarExpr:
+		return x.name.GetLexeme()
+	case *AssignExpr:
+		return x.name.GetLexeme() + " = " + exprSource(x.value)
+	case *UnaryExpr:
+		return x.operator.GetLexeme() + exprSource(x.right)
+	case *BinaryExpr:
+		return exprSource(x.left) + " " + x.operator.GetLexeme() + " " + exprSource(x.right)
+	case *LogicalExpr:
+		return exprSource(x.left) + " " + x.operator.GetLexeme() + " " + exprSource(x.right)
+	case *GroupingExpr:
+		return "(" + exprSource(x.expression) + ")"
+	case *GetExpr:
+		return exprSource(x.object) + "." + x.name.GetLexeme()
+	case *CallExpr:
+		args := make([]string, len(x.arguments))
+		for idx, argument := range x.arguments {
+			args[idx] = exprSource(argument)
+		}
+		return exprSource(x.callee) + "(" + strings.Join(args, ", ") + ")"
+	}
+	return "?"
+}
+
+// Render a statement back to almond source
+func stmtSource(statement Stmt, indent string) string {
+	switch s := statement.(type) {
+	case *ExprStmt:
+		return indent + exprSource(s.expression) + ";"
+	case *PrintStmt:
+		return indent + "print " + exprSource(s.expression) + ";"
+	case *AssertStmt:
+		if s.message != nil {
+			return indent + "assert " + exprSource(s.condition) + ", " + exprSource(s.message) + ";"
+		}
+		return indent + "assert " + exprSource(s.condition) + ";"
+	case *VarStmt:
+		if s.initializer != nil {
+			return indent + "var " + s.name.GetLexeme() + " = " + exprSource(s.initializer) + ";"
+		}
+		return indent + "var " + s.name.GetLexeme() + ";"
+	case *ReturnStmt:
+		if s.value != nil {
+			return indent + "return " + exprSource(s.value) + ";"
+		}
+		return indent + "return;"
+	case *BlockStmt:
+		return indent + "{\n" + stmtsSource(s.statements, indent+"    ") + indent + "}"
+	case *IfStmt:
+		out := indent + "if (" + exprSource(s.condition) + ")" + branchSource(s.thenBranch, indent)
+		if s.elseBranch != nil {
+			out += "\n" + indent + "else" + branchSource(s.elseBranch, indent)
+		}
+		return out
+	case *WhileStmt:
+		return indent + "while (" + exprSource(s.condition) + ")" + branchSource(s.body, indent)
+	case *FnStmt:
+		params := make([]string, len(s.params))
+		for idx, param := range s.params {
+			params[idx] = param.GetLexeme()
+		}
+		return indent + "fn " + s.name.GetLexeme() + "(" + strings.Join(params, ", ") + ") {\n" +
+			stmtsSource(s.body, indent+"    ") + indent + "}"
+	}
+	return indent + "?"
+}
+
+// Render the body of an if or while, blocks stay on the same line
+func branchSource(statement Stmt, indent string) string {
+	if _, ok := statement.(*BlockStmt); ok {
+		return " " + strings.TrimPrefix(stmtSource(statement, indent), indent)
+	}
+	return "\n" + stmtSource(statement, indent+"    ")
+}
+
+// Render a statement list, one statement per line
+func stmtsSource(statements []Stmt, indent string) string {
+	out := ""
+	for _, statement := range statements {
+		out += stmtSource(statement, indent) + "\n"
+	}
+	return out
+}
+
+// Render a runtime value for messages, strings are quoted
+func valueSource(value Object) string {
+	if value.GetKind() == STRING {
+		return strconv.Quote(value.String())
+	}
+	return value.String()
+}
+
+// Operators that compare two values
+func isComparison(t TokenType) bool {
+	switch t {
+	case EQUALS, NOT_EQUALS, GREATER, GREATER_EQUAL, LESS, LESS_EQUAL:
+		return true
+	}
+	return false
+}
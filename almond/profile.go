@@ -0,0 +1,312 @@
+package almond
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"time"
+)
+
+// Name of the frame holding top level code
+const profileTopLevel = "<script>"
+
+// Hooks that time almond functions and lines, written out in the pprof
+// format read by `go tool pprof`. Time between hook events is charged to
+// the line running in the innermost call, so profiled scripts run on the
+// tree-walker. A tail call replaces its caller's node like it replaces the frame.
+type Profiler struct {
+	filename string
+	root     *profileNode
+	current  *profileNode
+	start    time.Time
+	last     time.Time
+}
+
+// Function and line a profile sample is charged to
+type profileLocation struct {
+	function string
+	line     int
+}
+
+// Call tree node, children are the lines and calls made from the parent call
+type profileNode struct {
+	parent     *profileNode
+	location   profileLocation
+	children   map[profileLocation]*profileNode
+	statements int64
+	nanos      int64
+}
+
+func (n *profileNode) child(location profileLocation) *profileNode {
+	if node, ok := n.children[location]; ok {
+		return node
+	}
+
+	if n.children == nil {
+		n.children = map[profileLocation]*profileNode{}
+	}
+
+	node := &profileNode{parent: n, location: location}
+	n.children[location] = node
+	return node
+}
+
+// Profile a script, filename is shown as the source of every function
+func NewProfiler(filename string) *Profiler {
+	root := &profileNode{}
+	now := time.Now()
+	return &Profiler{filename, root, root.child(profileLocation{profileTopLevel, 0}), now, now}
+}
+
+// charge the time since the last event to the current line
+func (p *Profiler) charge() {
+	now := time.Now()
+	p.current.nanos += int64(now.Sub(p.last))
+	p.last = now
+}
+
+func (p *Profiler) BeforeStmt(pos Position, stmt Stmt, env EnvView) {
+	p.charge()
+	p.current = p.current.parent.child(profileLocation{p.current.location.function, pos.Line})
+	p.current.statements++
+}
+
+func (p *Profiler) EnterCall(pos Position, name string, args []Value, env EnvView) {
+	p.charge()
+	p.current = p.current.child(profileLocation{name, pos.Line})
+}
+
+func (p *Profiler) ExitCall(pos Position, name string, result Value, env EnvView) {
+	p.charge()
+
+	// the top level frame is never left
+	if p.current.parent != p.root {
+		p.current = p.current.parent
+	}
+}
+
+func (p *Profiler) OnError(pos Position, err error, env EnvView) {
+	p.charge()
+}
+
+// Write the profile gzipped to a file
+func (p *Profiler) WriteFile(filename string) error {
+	file, err := os.Create(filename)
+
+	if err != nil {
+		return err
+	}
+
+	err = p.WriteProfile(file)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Write the profile so far as a gzipped pprof protobuf
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	p.charge()
+
+	zw := gzip.NewWriter(w)
+
+	_, err := zw.Write(p.encode())
+
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ----- pprof encoding, see github.com/google/pprof/proto/profile.proto
+
+// Field numbers of the messages written
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocations     = 4
+	profileFunctions     = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID       = 1
+	functionName     = 2
+	functionFilename = 4
+)
+
+// Profile message built from the call tree
+type profileEncoder struct {
+	out       protoBuffer
+	strings   map[string]int
+	table     []string
+	functions map[string]uint64
+	locations map[profileLocation]uint64
+}
+
+func (p *Profiler) encode() []byte {
+	e := &profileEncoder{
+		strings:   map[string]int{},
+		functions: map[string]uint64{},
+		locations: map[profileLocation]uint64{},
+	}
+	e.str("")
+
+	e.valueType(profileSampleType, "statements", "count")
+	e.valueType(profileSampleType, "cpu", "nanoseconds")
+	e.samples(p.root)
+
+	var body protoBuffer
+
+	for location, id := range e.locations {
+		var line protoBuffer
+		line.uint(lineFunctionID, e.function(location.function, p.filename))
+		line.int(lineLine, int64(location.line))
+
+		body.uint(locationID, id)
+		body.message(locationLine, line)
+		e.out.message(profileLocations, body)
+		body.Reset()
+	}
+
+	for name, id := range e.functions {
+		body.uint(functionID, id)
+		body.int(functionName, int64(e.str(name)))
+		body.int(functionFilename, int64(e.str(p.filename)))
+		e.out.message(profileFunctions, body)
+		body.Reset()
+	}
+
+	e.out.int(profileTimeNanos, p.start.UnixNano())
+	e.out.int(profileDurationNanos, int64(p.last.Sub(p.start)))
+	e.valueType(profilePeriodType, "cpu", "nanoseconds")
+	e.out.int(profilePeriod, 1)
+
+	// strings last, every other message has added its own
+	for _, s := range e.table {
+		e.out.bytes(profileStringTable, []byte(s))
+	}
+	return e.out.Bytes()
+}
+
+// one sample for each node with time or statements, its stack leaf first
+func (e *profileEncoder) samples(node *profileNode) {
+	if node.location.function != "" && (node.nanos > 0 || node.statements > 0) {
+		var stack []uint64
+
+		for frame := node; frame.parent != nil; frame = frame.parent {
+			stack = append(stack, e.location(frame.location))
+		}
+
+		var sample protoBuffer
+		sample.packed(sampleLocationID, stack)
+		sample.packed(sampleValue, []uint64{uint64(node.statements), uint64(node.nanos)})
+		e.out.message(profileSample, sample)
+	}
+
+	for _, child := range node.children {
+		e.samples(child)
+	}
+}
+
+func (e *profileEncoder) valueType(field int, kind, unit string) {
+	var value protoBuffer
+	value.int(valueTypeType, int64(e.str(kind)))
+	value.int(valueTypeUnit, int64(e.str(unit)))
+	e.out.message(field, value)
+}
+
+// index in the string table
+func (e *profileEncoder) str(s string) int {
+	if idx, ok := e.strings[s]; ok {
+		return idx
+	}
+
+	e.strings[s] = len(e.table)
+	e.table = append(e.table, s)
+	return len(e.table) - 1
+}
+
+// ids start at 1, 0 means unset in pprof
+func (e *profileEncoder) location(location profileLocation) uint64 {
+	if id, ok := e.locations[location]; ok {
+		return id
+	}
+
+	e.locations[location] = uint64(len(e.locations) + 1)
+	return e.locations[location]
+}
+
+func (e *profileEncoder) function(name, filename string) uint64 {
+	if id, ok := e.functions[name]; ok {
+		return id
+	}
+
+	e.functions[name] = uint64(len(e.functions) + 1)
+	return e.functions[name]
+}
+
+// Protobuf wire format writer, zero values are left out like proto3 does
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (b *protoBuffer) varint(n uint64) {
+	for n >= 0x80 {
+		b.WriteByte(byte(n) | 0x80)
+		n >>= 7
+	}
+	b.WriteByte(byte(n))
+}
+
+// field number and wire type: 0 for varints, 2 for length prefixed data
+func (b *protoBuffer) tag(field, wireType int) {
+	b.varint(uint64(field<<3 | wireType))
+}
+
+func (b *protoBuffer) uint(field int, n uint64) {
+	if n != 0 {
+		b.tag(field, 0)
+		b.varint(n)
+	}
+}
+
+func (b *protoBuffer) int(field int, n int64) {
+	b.uint(field, uint64(n))
+}
+
+// length prefixed field, strings are always written so the table keeps its indexes
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.tag(field, 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protoBuffer) message(field int, message protoBuffer) {
+	b.bytes(field, message.Bytes())
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	var list protoBuffer
+
+	for _, n := range values {
+		list.varint(n)
+	}
+	b.bytes(field, list.Bytes())
+}
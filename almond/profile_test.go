@@ -0,0 +1,205 @@
+package almond
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Time spent in a call is charged to the line running in each frame of its stack
+func TestProfilerChargesCallStack(t *testing.T) {
+	profiler := NewProfiler("nap.al")
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Capabilities: &AllCapabilities, Hooks: profiler})
+
+	_, err := engine.Eval(`
+		fn nap() {
+			sleepMS(20);
+		}
+		fn outer() {
+			var x = 1;
+			nap();
+		}
+		outer();`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := profiler.WriteProfile(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := decodeProfile(t, out.Bytes())
+	want := "<NATIVE SLEEP FN>:3 fn nap:3 fn outer:7 <script>:9"
+
+	if profile.nanos[want] < int64(20*time.Millisecond) {
+		t.Errorf("got %v for %q in %v", time.Duration(profile.nanos[want]), want, profile.nanos)
+	}
+
+	if profile.statements["fn outer:6 <script>:9"] != 1 {
+		t.Errorf("got statements %v", profile.statements)
+	}
+
+	if strings.Join(profile.types, " ") != "statements/count cpu/nanoseconds" {
+		t.Errorf("got sample types %v", profile.types)
+	}
+
+	for _, filename := range profile.filenames {
+		if filename != "nap.al" {
+			t.Errorf("got filename %q", filename)
+		}
+	}
+}
+
+// Profiled tail recursion runs in constant stack, the calls replace each other
+func TestProfilerKeepsTailCalls(t *testing.T) {
+	profiler := NewProfiler("down.al")
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, Hooks: profiler})
+
+	_, err := engine.Eval(`fn down(n) { if (n == 0) return 0; return down(n - 1); }
+		down(100000);`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := profiler.WriteProfile(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	// an if and a return in each call
+	profile := decodeProfile(t, out.Bytes())
+
+	if got := profile.statements["fn down:1 <script>:2"]; got != 200002 {
+		t.Errorf("got statements %v", profile.statements)
+	}
+}
+
+// Samples of a decoded profile keyed by their stack, leaf first
+type decodedProfile struct {
+	types      []string
+	statements map[string]int64
+	nanos      map[string]int64
+	filenames  []string
+}
+
+// Just enough of a protobuf reader to check the fields the profiler writes
+func decodeProfile(t *testing.T, data []byte) decodedProfile {
+	t.Helper()
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := io.ReadAll(reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := protoFields(t, raw)
+	table := []string{}
+	for _, s := range fields[profileStringTable] {
+		table = append(table, string(s.([]byte)))
+	}
+
+	functions := map[uint64]string{}
+	profile := decodedProfile{statements: map[string]int64{}, nanos: map[string]int64{}}
+
+	for _, message := range fields[profileFunctions] {
+		function := protoFields(t, message.([]byte))
+		functions[protoUint(function, functionID)] = table[protoUint(function, functionName)]
+		profile.filenames = append(profile.filenames, table[protoUint(function, functionFilename)])
+	}
+
+	locations := map[uint64]string{}
+	for _, message := range fields[profileLocations] {
+		location := protoFields(t, message.([]byte))
+		line := protoFields(t, location[locationLine][0].([]byte))
+		locations[protoUint(location, locationID)] = fmt.Sprintf("%s:%d", functions[protoUint(line, lineFunctionID)], protoUint(line, lineLine))
+	}
+
+	for _, message := range fields[profileSampleType] {
+		valueType := protoFields(t, message.([]byte))
+		profile.types = append(profile.types, table[protoUint(valueType, valueTypeType)]+"/"+table[protoUint(valueType, valueTypeUnit)])
+	}
+
+	for _, message := range fields[profileSample] {
+		sample := protoFields(t, message.([]byte))
+		var stack []string
+
+		for _, id := range protoPacked(t, sample[sampleLocationID][0].([]byte)) {
+			stack = append(stack, locations[id])
+		}
+
+		values := protoPacked(t, sample[sampleValue][0].([]byte))
+		key := strings.Join(stack, " ")
+		profile.statements[key] += int64(values[0])
+		profile.nanos[key] += int64(values[1])
+	}
+	return profile
+}
+
+// fields of a message, varints as uint64 and length prefixed data as []byte
+func protoFields(t *testing.T, data []byte) map[int][]any {
+	t.Helper()
+	fields := map[int][]any{}
+
+	for len(data) > 0 {
+		tag := protoVarint(t, &data)
+		field := int(tag >> 3)
+
+		switch tag & 7 {
+		case 0:
+			fields[field] = append(fields[field], protoVarint(t, &data))
+		case 2:
+			n := protoVarint(t, &data)
+			fields[field] = append(fields[field], data[:n])
+			data = data[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+	return fields
+}
+
+func protoVarint(t *testing.T, data *[]byte) uint64 {
+	t.Helper()
+	var n uint64
+
+	for shift := 0; len(*data) > 0; shift += 7 {
+		b := (*data)[0]
+		*data = (*data)[1:]
+		n |= uint64(b&0x7f) << shift
+
+		if b < 0x80 {
+			return n
+		}
+	}
+
+	t.Fatal("truncated varint")
+	return 0
+}
+
+func protoUint(fields map[int][]any, field int) uint64 {
+	if len(fields[field]) == 0 {
+		return 0
+	}
+	return fields[field][0].(uint64)
+}
+
+func protoPacked(t *testing.T, data []byte) []uint64 {
+	var values []uint64
+
+	for len(data) > 0 {
+		values = append(values, protoVarint(t, &data))
+	}
+	return values
+}
@@ -0,0 +1,167 @@
+package almond
+
+// Names stored in the slots of a function or block scope
+type frameInfo struct {
+	names []string
+}
+
+// Slot of a name, -1 when the scope does not declare it.
+// A repeated parameter name binds to its last slot.
+func (f *frameInfo) slot(name string) int {
+	for idx := len(f.names) - 1; idx >= 0; idx-- {
+		if f.names[idx] == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+// add a name once, returning its slot
+func (f *frameInfo) declare(name string) int {
+	if slot := f.slot(name); slot >= 0 {
+		return slot
+	}
+	f.names = append(f.names, name)
+	return len(f.names) - 1
+}
+
+// Resolves local variables to (depth, slot) pairs ahead of running.
+// A name binds to the innermost scope declaring it anywhere in its body;
+// reads of a slot whose declaration has not run yet fall back to a lookup
+// by name, so resolved code behaves exactly like name based scoping.
+// Top level names are globals and stay in maps.
+type resolver struct {
+	scopes []*frameInfo
+	// function bodies being resolved
+	functions int
+}
+
+// Resolve parsed statements in place, they run as top level code
+func resolve(statements []Stmt) {
+	r := resolver{}
+	r.stmts(statements)
+}
+
+// Names a statement list declares in its own scope,
+// branches without braces declare into the enclosing scope
+func declaredNames(frame *frameInfo, statements []Stmt) {
+	for _, statement := range statements {
+		switch s := statement.(type) {
+		case *VarStmt:
+			frame.declare(s.name.GetLexeme())
+		case *FnStmt:
+			frame.declare(s.name.GetLexeme())
+		case *IfStmt:
+			declaredNames(frame, []Stmt{s.thenBranch, s.elseBranch})
+		case *WhileStmt:
+			declaredNames(frame, []Stmt{s.body})
+		}
+	}
+}
+
+// slot for a declaration in the innermost scope, -1 for globals
+func (r *resolver) declaration(name Token) int {
+	if len(r.scopes) == 0 {
+		return -1
+	}
+	return r.scopes[len(r.scopes)-1].slot(name.GetLexeme())
+}
+
+// depth and slot of a reference, depth -1 for globals
+func (r *resolver) reference(name Token) (int, int) {
+	for idx := len(r.scopes) - 1; idx >= 0; idx-- {
+		if slot := r.scopes[idx].slot(name.GetLexeme()); slot >= 0 {
+			return len(r.scopes) - 1 - idx, slot
+		}
+	}
+	return -1, 0
+}
+
+func (r *resolver) stmts(statements []Stmt) {
+	for _, statement := range statements {
+		r.stmt(statement)
+	}
+}
+
+func (r *resolver) stmt(statement Stmt) {
+	switch s := statement.(type) {
+	case *ExprStmt:
+		r.expr(s.expression)
+	case *PrintStmt:
+		r.expr(s.expression)
+	case *AssertStmt:
+		r.expr(s.condition)
+		r.expr(s.message)
+	case *BlockStmt:
+		s.frame = &frameInfo{}
+		declaredNames(s.frame, s.statements)
+
+		// blocks without declarations share the enclosing scope
+		if len(s.frame.names) == 0 {
+			r.stmts(s.statements)
+			return
+		}
+
+		r.scopes = append(r.scopes, s.frame)
+		r.stmts(s.statements)
+		r.scopes = r.scopes[:len(r.scopes)-1]
+	case *IfStmt:
+		r.expr(s.condition)
+		r.stmt(s.thenBranch)
+		r.stmt(s.elseBranch)
+	case *WhileStmt:
+		r.expr(s.condition)
+		r.stmt(s.body)
+	case *ReturnStmt:
+		r.expr(s.value)
+
+		// a returned call can reuse the frame of the function
+		_, call := s.value.(*CallExpr)
+		s.tail = call && r.functions > 0
+	case *FnStmt:
+		s.slot = r.declaration(s.name)
+
+		// parameters take the first slots in order
+		s.frame = &frameInfo{}
+		for _, param := range s.params {
+			s.frame.names = append(s.frame.names, param.GetLexeme())
+		}
+		declaredNames(s.frame, s.body)
+
+		r.scopes = append(r.scopes, s.frame)
+		r.functions++
+		r.stmts(s.body)
+		r.functions--
+		r.scopes = r.scopes[:len(r.scopes)-1]
+	case *VarStmt:
+		r.expr(s.initializer)
+		s.slot = r.declaration(s.name)
+	}
+}
+
+func (r *resolver) expr(expression Expr) {
+	switch x := expression.(type) {
+	case *VarExpr:
+		x.depth, x.slot = r.reference(x.name)
+	case *AssignExpr:
+		r.expr(x.value)
+		x.depth, x.slot = r.reference(x.name)
+	case *UnaryExpr:
+		r.expr(x.right)
+	case *BinaryExpr:
+		r.expr(x.left)
+		r.expr(x.right)
+	case *LogicalExpr:
+		r.expr(x.left)
+		r.expr(x.right)
+	case *GroupingExpr:
+		r.expr(x.expression)
+	case *GetExpr:
+		r.expr(x.object)
+	case *CallExpr:
+		r.expr(x.callee)
+		for _, argument := range x.arguments {
+			r.expr(argument)
+		}
+	}
+}
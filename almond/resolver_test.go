@@ -0,0 +1,47 @@
+package almond
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Resolved slots must give the same results as looking names up at runtime
+func TestResolvedScoping(t *testing.T) {
+	src := `
+		fn outer() { fn inner() { return x; } var x = "late"; return inner(); }
+		print outer();
+		var a = "global";
+		{ print a; var a = "block"; print a; }
+		{ var a = a + "!"; print a; }
+		fn dup(p, p) { return p; }
+		print dup(1, 2);
+		var fns = null;
+		for (var i = 0; i < 3; i = i + 1) { var j = i; fn show() { return j; } if (i == 1) fns = show; }
+		print fns();
+		fn shadow(n) { { var n = n * 2; print n; } return n; }
+		print shadow(5);
+		fn counter() { var c = 0; fn inc() { c = c + 1; return c; } return inc; }
+		var k = counter(); k(); print k();
+		fn early() { print q; }
+		var q = "global q";
+		early();
+		fn assignOuter() { { var w = 1; } w = 5; }
+		assignOuter();`
+
+	want := "late\nglobal\nblock\nglobal!\n2\n1\n10\n5\n2\nglobal q\n"
+
+	for _, treeWalk := range []bool{false, true} {
+		var stdout bytes.Buffer
+		engine, _ := NewEngine(Options{Stdout: &stdout, Stderr: io.Discard, TreeWalk: treeWalk})
+
+		_, err := engine.Eval(src)
+
+		if err == nil || err.Error() != "Undefined variable 'w'. at line 19" {
+			t.Errorf("tree-walk %v: got error %v", treeWalk, err)
+		}
+		if stdout.String() != want {
+			t.Errorf("tree-walk %v: got output\n%s", treeWalk, stdout.String())
+		}
+	}
+}
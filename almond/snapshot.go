@@ -0,0 +1,196 @@
+package almond
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Version written to and accepted from snapshots
+const snapshotVersion = 1
+
+// Saved global state of an engine
+type snapshot struct {
+	Version int              `json:"version"`
+	Globals []snapshotGlobal `json:"globals"`
+}
+
+// One saved global, Type picks which other field is set
+type snapshotGlobal struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Number string `json:"number,omitempty"`
+	String string `json:"string,omitempty"`
+	Source string `json:"source,omitempty"`
+	Native string `json:"native,omitempty"`
+}
+
+// Serialize global variables and functions to JSON.
+// Natives are saved by name, host values cannot be saved.
+func (en *Engine) Snapshot() ([]byte, error) {
+	names := make([]string, 0, len(en.inter.env.lut))
+	for name := range en.inter.env.lut {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	snap := snapshot{Version: snapshotVersion, Globals: []snapshotGlobal{}}
+
+	for _, name := range names {
+		global, err := en.snapshotValue(name, en.inter.env.lut[name])
+
+		if err != nil {
+			return nil, err
+		}
+		snap.Globals = append(snap.Globals, global)
+	}
+
+	return json.MarshalIndent(snap, "", "  ")
+}
+
+// Encode one global
+func (en *Engine) snapshotValue(name string, value Object) (snapshotGlobal, error) {
+	global := snapshotGlobal{Name: name}
+
+	switch value.kind {
+	case kindNull:
+		global.Type = "null"
+	case kindTrue:
+		global.Type = "true"
+	case kindFalse:
+		global.Type = "false"
+	case kindNumber:
+		global.Type = "number"
+		global.Number = strconv.FormatFloat(value.num, 'g', -1, 64)
+	case kindString:
+		global.Type = "string"
+		global.String = value.ref.(string)
+	case kindCallable:
+		fn := value.ref.(Callable)
+
+		// builtins are restored from the new engine by name
+		if native, ok := en.nativeName(fn); ok {
+			global.Type = "native"
+			global.Native = native
+			break
+		}
+
+		user, ok := fn.(*FunctionCall)
+
+		if !ok {
+			return global, fmt.Errorf("cannot snapshot '%s': native %s is not registered by name", name, fn.ToString())
+		}
+
+		if user.closure != &en.inter.env {
+			return global, fmt.Errorf("cannot snapshot '%s': function captures local variables", name)
+		}
+
+		global.Type = "function"
+		global.Source = stmtSource(&user.declaration, "")
+	default:
+		return global, fmt.Errorf("cannot snapshot '%s': %s values cannot be serialized", name, value.GetKindStr())
+	}
+	return global, nil
+}
+
+// Name a builtin or registered native is installed under
+func (en *Engine) nativeName(fn Callable) (string, bool) {
+	for name, value := range en.inter.env.enclosing.lut {
+		if value.kind == kindCallable && value.ref == fn {
+			return name, true
+		}
+	}
+
+	if native, ok := fn.(*NativeFunc); ok {
+		if value, found := en.inter.env.lookup(native.name); found && value.ref == fn {
+			return native.name, true
+		}
+	}
+	return "", false
+}
+
+// Load globals saved by Snapshot, replacing existing ones with the same name
+func (en *Engine) Restore(data []byte) error {
+	var snap snapshot
+
+	err := json.Unmarshal(data, &snap)
+
+	if err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", snap.Version, snapshotVersion)
+	}
+
+	// decode everything before touching the engine
+	values := make([]Object, len(snap.Globals))
+
+	for idx, global := range snap.Globals {
+		values[idx], err = en.restoreValue(global)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err = en.inter.adopt(values...)
+
+	if err != nil {
+		return err
+	}
+
+	for idx, global := range snap.Globals {
+		en.inter.env.Define(global.Name, values[idx])
+	}
+	return nil
+}
+
+// Decode one global
+func (en *Engine) restoreValue(global snapshotGlobal) (Object, error) {
+	switch global.Type {
+	case "null":
+		return nullValue(), nil
+	case "true":
+		return boolValue(true), nil
+	case "false":
+		return boolValue(false), nil
+	case "number":
+		num, err := strconv.ParseFloat(global.Number, 64)
+
+		if err != nil {
+			return Object{}, fmt.Errorf("snapshot '%s': %w", global.Name, err)
+		}
+		return numberValue(num), nil
+	case "string":
+		return stringValue(global.String), nil
+	case "native":
+		value, ok := en.inter.env.lookup(global.Native)
+
+		if !ok || value.kind != kindCallable {
+			return Object{}, fmt.Errorf("snapshot '%s': native '%s' is not registered", global.Name, global.Native)
+		}
+		return value, nil
+	case "function":
+		statements, err := parseSource(global.Source)
+
+		if err != nil {
+			return Object{}, fmt.Errorf("snapshot '%s': %w", global.Name, err)
+		}
+
+		if len(statements) != 1 {
+			return Object{}, errors.New("snapshot '" + global.Name + "': expected one function")
+		}
+
+		fn, ok := statements[0].(*FnStmt)
+
+		if !ok {
+			return Object{}, errors.New("snapshot '" + global.Name + "': expected a function")
+		}
+		return callableValue(NewFunctionCall(*fn, &en.inter.env)), nil
+	}
+
+	return Object{}, fmt.Errorf("snapshot '%s': unknown type %q", global.Name, global.Type)
+}
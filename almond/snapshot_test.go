@@ -0,0 +1,176 @@
+package almond
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+	engine.RegisterFunc("twice", func(n float64) float64 { return n * 2 })
+
+	_, err := engine.Eval(`
+		var count = 3;
+		var name = "almond, nuts: é";
+		var empty = null;
+		var yes = true;
+		var no = false;
+		var big = 0.1 + 0.2;
+		fn greet(who) { return "hi " + who; }
+		fn down(n) { if (n == 0) return "done"; return down(n - 1); }
+		fn countUp() { count = count + 1; return count; }
+		var hello = greet;
+		var now = clock;
+		var double = twice;`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := engine.Snapshot()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, treeWalk := range []bool{false, true} {
+		restored, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, TreeWalk: treeWalk})
+		restored.RegisterFunc("twice", func(n float64) float64 { return n * 3 })
+
+		if err := restored.Restore(data); err != nil {
+			t.Fatalf("tree %v: %v", treeWalk, err)
+		}
+
+		values := [][2]string{
+			{`count;`, "3"},
+			{`name;`, "almond, nuts: é"},
+			{`empty;`, "NULL"},
+			{`yes & !no;`, "TRUE"},
+			{`big == 0.1 + 0.2;`, "TRUE"},
+			{`greet("al");`, "hi al"},
+			{`hello("bo");`, "hi bo"},
+			{`down(100000);`, "done"},
+			{`countUp(); countUp();`, "5"},
+			{`now() >= 0;`, "TRUE"},
+			// natives are looked up by name in the restoring engine
+			{`double(2);`, "6"},
+		}
+
+		for _, c := range values {
+			if value, err := restored.Eval(c[0]); err != nil || value.String() != c[1] {
+				t.Errorf("tree %v, %s: got %q, %v", treeWalk, c[0], value.String(), err)
+			}
+		}
+
+		// functions run against the restoring engine's globals
+		if value, _ := restored.GetGlobal("count"); value.String() != "5" {
+			t.Errorf("tree %v: count is %s", treeWalk, value.String())
+		}
+
+		// a second snapshot saves the same globals
+		again, err := restored.Snapshot()
+
+		if err != nil || !strings.Contains(string(again), `"name": "down"`) {
+			t.Errorf("tree %v: snapshot of restored engine: %v", treeWalk, err)
+		}
+	}
+}
+
+func TestSnapshotRejects(t *testing.T) {
+	cases := map[string]string{
+		`fn outer() { var n = 1; fn inner() { return n; } return inner; } var f = outer();`: "cannot snapshot 'f': function captures local variables",
+	}
+
+	for src, want := range cases {
+		engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+
+		if _, err := engine.Eval(src); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := engine.Snapshot(); err == nil || err.Error() != want {
+			t.Errorf("%s: got %v, want %q", src, err, want)
+		}
+	}
+
+	// host values cannot be serialized
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+	engine.SetGlobal("record", hostValue(&hostRecord{"x"}))
+
+	if _, err := engine.Snapshot(); err == nil || err.Error() != "cannot snapshot 'record': HOST values cannot be serialized" {
+		t.Errorf("host value: got %v", err)
+	}
+
+	// natives are only saved under the name they were registered with
+	engine, _ = NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+	fn, _ := NewNativeFunc("hidden", func() int { return 1 })
+	engine.SetGlobal("alias", callableValue(fn))
+
+	if _, err := engine.Snapshot(); err == nil || !strings.Contains(err.Error(), "cannot snapshot 'alias': native") {
+		t.Errorf("unnamed native: got %v", err)
+	}
+}
+
+func TestRestoreRejects(t *testing.T) {
+	global := func(fields string) string {
+		return `{"version": 1, "globals": [{"name": "g", ` + fields + `}]}`
+	}
+
+	cases := map[string]string{
+		`not json`:                                                    "invalid snapshot",
+		`{"version": 2, "globals": []}`:                               "unsupported snapshot version 2",
+		`{"globals": []}`:                                             "unsupported snapshot version 0",
+		global(`"type": "number", "number": "x"`):                     "snapshot 'g': strconv.ParseFloat",
+		global(`"type": "native", "native": "missing"`):               "native 'missing' is not registered",
+		global(`"type": "native", "native": "answer"`):                "native 'answer' is not registered",
+		global(`"type": "function", "source": "fn broken( {"`):        "snapshot 'g'",
+		global(`"type": "function", "source": "print 1;"`):            "snapshot 'g': expected a function",
+		global(`"type": "function", "source": "fn a() {} fn b() {}"`): "snapshot 'g': expected one function",
+		global(`"type": "bogus"`):                                     `snapshot 'g': unknown type "bogus"`,
+	}
+
+	for data, want := range cases {
+		engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard})
+		engine.SetGlobal("answer", numberValue(42))
+
+		// a failed restore leaves the engine as it was
+		bad := strings.Replace(data, `[{"name": "g"`, `[{"name": "answer", "type": "null"}, {"name": "g"`, 1)
+
+		if err := engine.Restore([]byte(bad)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", data, err, want)
+		}
+
+		if value, _ := engine.GetGlobal("answer"); value.String() != "42" {
+			t.Errorf("%s: answer became %s", data, value.String())
+		}
+	}
+}
+
+// Snapshots are plain JSON listing globals by name
+func TestSnapshotFormat(t *testing.T) {
+	engine, _ := NewEngine(Options{Stdout: io.Discard, Stderr: io.Discard, NoPrelude: true})
+	engine.Eval(`var b = 1; var a = "x"; fn f() { return b; }`)
+
+	data, err := engine.Snapshot()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var snap snapshot
+
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, global := range snap.Globals {
+		names = append(names, global.Name+":"+global.Type)
+	}
+
+	if got := strings.Join(names, " "); snap.Version != snapshotVersion || got != "a:string b:number f:function" {
+		t.Errorf("version %d, globals %s", snap.Version, got)
+	}
+}
@@ -1,22 +1,22 @@
 package almond
 
-import (
-	"fmt"
-)
-
 type Stmt interface {
 	Evaluate(e *Environment) error
+	Line() int
 }
 
 // EXPRESSION STATEMENTS
 type ExprStmt struct {
+	line       int
 	expression Expr
 }
 
-func NewExprStmt(e Expr) *ExprStmt {
-	return &ExprStmt{e}
+func NewExprStmt(l int, e Expr) *ExprStmt {
+	return &ExprStmt{l, e}
 }
 
+func (x ExprStmt) Line() int { return x.line }
+
 func (x ExprStmt) Evaluate(e *Environment) error {
 	_, err := x.expression.Evaluate(e)
 
@@ -25,18 +25,24 @@ func (x ExprStmt) Evaluate(e *Environment) error {
 
 // BLOCK STATMENTS
 type BlockStmt struct {
+	line       int
 	statements []Stmt
+	// locals found by the resolver, nil when unresolved
+	frame *frameInfo
 }
 
-func NewBlockStmt(s []Stmt) *BlockStmt {
-	return &BlockStmt{s}
+func NewBlockStmt(l int, s []Stmt) *BlockStmt {
+	return &BlockStmt{l, s, nil}
 }
 
+func (b BlockStmt) Line() int { return b.line }
+
 func (b BlockStmt) Evaluate(e *Environment) error {
-	blockEnv := NewEnclosedEnv(e)
+	blockEnv := e.enterBlock(b.frame)
+	defer e.leaveBlock(blockEnv)
 
 	for _, statement := range b.statements {
-		err := statement.Evaluate(blockEnv)
+		err := e.inter.execute(statement, blockEnv)
 		if err != nil {
 			return err
 		}
@@ -47,35 +53,107 @@ func (b BlockStmt) Evaluate(e *Environment) error {
 
 // PRINT STATEMENTS
 type PrintStmt struct {
+	keyword    Token
 	expression Expr
 }
 
-func NewPrintStmt(e Expr) *PrintStmt {
-	return &PrintStmt{e}
+func NewPrintStmt(k Token, e Expr) *PrintStmt {
+	return &PrintStmt{k, e}
 }
 
+func (p PrintStmt) Line() int { return p.keyword.GetLine() }
+
 func (p PrintStmt) Evaluate(e *Environment) error {
 	value, err := p.expression.Evaluate(e)
 
 	if err != nil {
 		return err
 	}
-	fmt.Println(value.String())
+	e.inter.print(value.String())
 
 	return nil
 }
 
+// ASSERT STATEMENTS
+type AssertStmt struct {
+	keyword   Token
+	condition Expr
+	message   Expr
+}
+
+func NewAssertStmt(k Token, c Expr, m Expr) *AssertStmt {
+	return &AssertStmt{k, c, m}
+}
+
+func (a AssertStmt) Line() int { return a.keyword.GetLine() }
+
+func (a AssertStmt) Evaluate(e *Environment) error {
+	var passed bool
+	detail := ""
+
+	// show both sides of a failed comparison
+	if b, ok := a.condition.(*BinaryExpr); ok && isComparison(b.operator.GetType()) {
+		right, err := b.right.Evaluate(e)
+
+		if err != nil {
+			return err
+		}
+
+		left, err := b.left.Evaluate(e)
+
+		if err != nil {
+			return err
+		}
+
+		result, err := binaryOp(e, b.operator, left, right)
+
+		if err != nil {
+			return err
+		}
+
+		passed = result.Bool()
+		detail = " (left: " + valueSource(left) + ", right: " + valueSource(right) + ")"
+	} else {
+		cond, err := a.condition.Evaluate(e)
+
+		if err != nil {
+			return err
+		}
+		passed = cond.Bool()
+	}
+
+	if passed {
+		return nil
+	}
+
+	message := "assert failed: " + exprSource(a.condition) + detail
+
+	if a.message != nil {
+		value, err := a.message.Evaluate(e)
+
+		if err != nil {
+			return err
+		}
+		message += ": " + value.String()
+	}
+
+	return RuntimeError(message, a.keyword)
+}
+
 // CONDITION STATEMENTS
 type IfStmt struct {
+	keyword    Token
 	condition  Expr
 	thenBranch Stmt
 	elseBranch Stmt
 }
 
-func NewIfStmt(c Expr, t Stmt, e Stmt) *IfStmt {
-	return &IfStmt{c, t, e}
+func NewIfStmt(k Token, c Expr, t Stmt, e Stmt) *IfStmt {
+	return &IfStmt{k, c, t, e}
 }
 
+func (i IfStmt) Line() int { return i.keyword.GetLine() }
+
 func (i IfStmt) Evaluate(e *Environment) error {
 	cond, err := i.condition.Evaluate(e)
 
@@ -84,14 +162,14 @@ func (i IfStmt) Evaluate(e *Environment) error {
 	}
 
 	if cond.Bool() {
-		err = i.thenBranch.Evaluate(e)
+		err = e.inter.execute(i.thenBranch, e)
 
 		if err != nil {
 			return err
 		}
 
 	} else if i.elseBranch != nil {
-		err = i.elseBranch.Evaluate(e)
+		err = e.inter.execute(i.elseBranch, e)
 
 		if err != nil {
 			return err
@@ -102,14 +180,17 @@ func (i IfStmt) Evaluate(e *Environment) error {
 
 // LOOPS FOR|WHILE
 type WhileStmt struct {
+	keyword   Token
 	condition Expr
 	body      Stmt
 }
 
-func NewWhileStmt(c Expr, b Stmt) *WhileStmt {
-	return &WhileStmt{c, b}
+func NewWhileStmt(k Token, c Expr, b Stmt) *WhileStmt {
+	return &WhileStmt{k, c, b}
 }
 
+func (w WhileStmt) Line() int { return w.keyword.GetLine() }
+
 func (w WhileStmt) Evaluate(e *Environment) error {
 	val, err := w.condition.Evaluate(e)
 
@@ -118,7 +199,13 @@ func (w WhileStmt) Evaluate(e *Environment) error {
 	}
 
 	for val.Bool() {
-		err = w.body.Evaluate(e)
+		err = e.inter.checkpoint(w.keyword)
+
+		if err != nil {
+			return err
+		}
+
+		err = e.inter.execute(w.body, e)
 
 		if err != nil {
 			return err
@@ -138,16 +225,30 @@ func (w WhileStmt) Evaluate(e *Environment) error {
 type ReturnStmt struct {
 	keyword Token
 	value   Expr
+	// set by the resolver when a function body returns a call
+	tail bool
 }
 
 func NewReturnStmt(key Token, val Expr) *ReturnStmt {
-	return &ReturnStmt{key, val}
+	return &ReturnStmt{key, val, false}
 }
 
+func (r ReturnStmt) Line() int { return r.keyword.GetLine() }
+
 func (r ReturnStmt) Evaluate(e *Environment) error {
-	value := *NewObject(NULL, nil)
+	value := nullValue()
 	var err error = nil
 
+	if r.tail && e.inter != nil {
+		call := r.value.(*CallExpr)
+		function, args, err := call.operands(e)
+
+		if err != nil {
+			return err
+		}
+		return returnCall(e, function, args, call.paren)
+	}
+
 	if r.value != nil {
 		value, err = r.value.Evaluate(e)
 
@@ -164,15 +265,20 @@ type FnStmt struct {
 	name   Token
 	params []Token
 	body   []Stmt
+	// set by the resolver, slot -1 defines by name
+	slot  int
+	frame *frameInfo
 }
 
 func NewFnStmt(n Token, p []Token, b []Stmt) *FnStmt {
-	return &FnStmt{n, p, b}
+	return &FnStmt{n, p, b, -1, nil}
 }
 
+func (f FnStmt) Line() int { return f.name.GetLine() }
+
 func (f FnStmt) Evaluate(e *Environment) error {
-	function := NewFunctionCall(f)
-	e.Define(f.name.lexeme, *NewObject(CALLABLE, function))
+	function := NewFunctionCall(f, e)
+	e.defineAt(f.slot, f.name.lexeme, callableValue(function))
 	return nil
 }
 
@@ -180,15 +286,19 @@ func (f FnStmt) Evaluate(e *Environment) error {
 type VarStmt struct {
 	name        Token
 	initializer Expr
+	// set by the resolver, -1 defines by name
+	slot int
 }
 
 func NewVarStmt(n Token, i Expr) *VarStmt {
-	return &VarStmt{n, i}
+	return &VarStmt{n, i, -1}
 }
 
+func (v VarStmt) Line() int { return v.name.GetLine() }
+
 func (v VarStmt) Evaluate(e *Environment) error {
 	if v.initializer == nil {
-		e.Define(v.name.GetLexeme(), *NewObject(NULL, nil))
+		e.defineAt(v.slot, v.name.GetLexeme(), nullValue())
 		return nil
 	}
 
@@ -198,7 +308,7 @@ func (v VarStmt) Evaluate(e *Environment) error {
 		return err
 	}
 
-	e.Define(v.name.GetLexeme(), value)
+	e.defineAt(v.slot, v.name.GetLexeme(), value)
 
 	return nil
 }
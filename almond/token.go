@@ -7,19 +7,20 @@ import (
 )
 
 type Token struct {
-	obj    Object
-	lexeme string
-	line   int
+	kind    TokenType
+	literal any
+	lexeme  string
+	line    int
 }
 
 // Ctor
 func NewToken(kind TokenType, lexeme string, literal string, line int) *Token {
 	// Based on the token type create the literal
-	var obj Object
+	var value any
 
 	switch kind {
 	case STRING:
-		obj = *NewObject(kind, literal)
+		value = literal
 	case NUMBER:
 		// Convert to number
 		s, err := strconv.ParseFloat(literal, 64)
@@ -29,30 +30,28 @@ func NewToken(kind TokenType, lexeme string, literal string, line int) *Token {
 			fmt.Println("Implementation Error: tokenizer incorrectly parsed number")
 			os.Exit(3)
 		}
-		obj = *NewObject(kind, s)
-	default:
-		obj = *NewObject(kind, nil)
+		value = s
 	}
 
-	thisToken := Token{obj, lexeme, line}
+	thisToken := Token{kind, value, lexeme, line}
 	return &thisToken
 }
 
 // Get the token type
-func (t *Token) GetType() TokenType {
-	return t.obj.GetKind()
+func (t Token) GetType() TokenType {
+	return t.kind
 }
 
 // Get the literal value
-func (t *Token) GetLiteral() any {
-	return t.obj.GetLiteral()
+func (t Token) GetLiteral() any {
+	return t.literal
 }
 
-func (t *Token) GetLiteralStr() string {
-	switch t.obj.GetKind() {
+func (t Token) GetLiteralStr() string {
+	switch t.kind {
 	case NUMBER:
 		// Assert type
-		s, ok := t.obj.GetLiteral().(float64)
+		s, ok := t.literal.(float64)
 		if ok {
 			return fmt.Sprintf("%f", s)
 		}
@@ -63,7 +62,7 @@ func (t *Token) GetLiteralStr() string {
 		os.Exit(4)
 	case STRING:
 		// Assert type
-		s, ok := t.obj.GetLiteral().(string)
+		s, ok := t.literal.(string)
 		if ok {
 			return s
 		}
@@ -77,21 +76,22 @@ func (t *Token) GetLiteralStr() string {
 	return ""
 }
 
-func (t *Token) GetObject() Object {
-	return t.obj
+// Runtime value of a literal token
+func (t Token) GetObject() Object {
+	return *NewObject(t.kind, t.literal)
 }
 
 // Get the lexeme value
-func (t *Token) GetLexeme() string {
+func (t Token) GetLexeme() string {
 	return t.lexeme
 }
 
 // Get the line value
-func (t *Token) GetLine() int {
+func (t Token) GetLine() int {
 	return t.line
 }
 
 // Convert token content to string
-func (t *Token) String() string {
-	return "Type:" + t.obj.GetKindStr() + " Lexeme:" + t.lexeme + " Literal:" + t.GetLiteralStr()
+func (t Token) String() string {
+	return "Type:" + t.kind.String() + " Lexeme:" + t.lexeme + " Literal:" + t.GetLiteralStr()
 }
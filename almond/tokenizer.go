@@ -1,6 +1,10 @@
 package almond
 
 import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
 	"unicode"
 )
 
@@ -9,45 +13,93 @@ func validIdentifier(c rune) bool {
 	return unicode.IsLetter(c) || unicode.IsNumber(c) || c == '_'
 }
 
+// Source comment kept for tooling such as the linter
+type Comment struct {
+	Line int
+	Text string
+}
+
+// Reads tokens lazily from a source, one call to Next at a time
 type Tokenizer struct {
-	start   int
-	current int
-	line    int
-	source  string
-	tokens  []Token
+	reader *bufio.Reader
+	// bytes of the token being scanned
+	lexeme   []byte
+	line     int
+	token    Token
+	found    bool
+	comments []Comment
+	out      io.Writer
+	faults   []error
+	// error other than io.EOF from the reader
+	readErr error
 }
 
 // Construct Tokenizer
 func NewTokenizer(source string) *Tokenizer {
-	tmp := Tokenizer{0, 0, 1, source, []Token{}}
-	return &tmp
+	return NewStreamTokenizer(strings.NewReader(source))
+}
+
+// Construct a Tokenizer reading from r as tokens are requested
+func NewStreamTokenizer(r io.Reader) *Tokenizer {
+	return &Tokenizer{reader: bufio.NewReader(r), line: 1, comments: []Comment{}, out: os.Stdout}
+}
+
+// Set where errors are printed
+func (s *Tokenizer) SetOutput(out io.Writer) {
+	s.out = out
+}
+
+// Errors found while tokenizing
+func (s *Tokenizer) Faults() []error {
+	return s.faults
 }
 
-// Check if end is reached
+// Report an error on the current line
+func (s *Tokenizer) error(message string) {
+	s.faults = append(s.faults, report(s.out, s.line, "", message))
+}
+
+// Comments found while tokenizing
+func (s *Tokenizer) Comments() []Comment {
+	return s.comments
+}
+
+// Check if end is reached, a failing reader ends the source
 func (s *Tokenizer) end() bool {
-	return s.current >= len(s.source)
+	_, err := s.reader.Peek(1)
+
+	if err != nil && err != io.EOF && s.readErr == nil {
+		s.readErr = err
+		s.error("Could not read source: " + err.Error())
+	}
+	return err != nil
 }
 
 // Move to next character
 func (s *Tokenizer) advance() rune {
-	s.current++
-	return rune(s.source[s.current-1])
+	c, _ := s.reader.ReadByte()
+	s.lexeme = append(s.lexeme, c)
+	return rune(c)
 }
 
 // Get current character
 func (s *Tokenizer) peek() rune {
-	if s.end() {
+	next, err := s.reader.Peek(1)
+
+	if err != nil {
 		return rune(0)
 	}
-	return rune(s.source[s.current])
+	return rune(next[0])
 }
 
 // Get next character
 func (s *Tokenizer) peekNext() rune {
-	if s.current+1 >= len(s.source) {
+	next, _ := s.reader.Peek(2)
+
+	if len(next) < 2 {
 		return rune(0)
 	} else {
-		return rune(s.source[s.current+1])
+		return rune(next[1])
 	}
 }
 
@@ -57,11 +109,11 @@ func (s *Tokenizer) match(expected rune) bool {
 		return false
 	}
 
-	if rune(s.source[s.current]) != expected {
+	if s.peek() != expected {
 		return false
 	}
 
-	s.current++
+	s.advance()
 	return true
 }
 
@@ -71,9 +123,8 @@ func (s *Tokenizer) processIdentifier() {
 		s.advance()
 	}
 
-	text := s.source[s.start:s.current]
-	tokType := TokenTypeLUT(text)
-	s.addToken(tokType, "")
+	text := string(s.lexeme)
+	s.emit(*NewToken(TokenTypeLUT(text), text, "", s.line))
 }
 
 // Parse int or decimal number
@@ -90,7 +141,8 @@ func (s *Tokenizer) processNumber() {
 		}
 	}
 
-	s.addToken(NUMBER, s.source[s.start:s.current])
+	text := string(s.lexeme)
+	s.emit(*NewToken(NUMBER, text, text, s.line))
 }
 
 // Parse string
@@ -103,19 +155,23 @@ func (s *Tokenizer) processString() {
 	}
 
 	if s.end() {
-		Error(s.line, "Unterminated string")
+		s.error("Unterminated string")
 		return
 	}
 	s.advance()
 
-	text := s.source[s.start+1 : s.current-1]
+	text := string(s.lexeme[1 : len(s.lexeme)-1])
 	s.addToken(STRING, text)
 }
 
-// append token to list
+// hand the scanned token to Next
 func (s *Tokenizer) addToken(tokType TokenType, literal string) {
-	lexeme := s.source[s.start:s.current]
-	s.tokens = append(s.tokens, *NewToken(tokType, lexeme, literal, s.line))
+	s.emit(*NewToken(tokType, string(s.lexeme), literal, s.line))
+}
+
+func (s *Tokenizer) emit(tok Token) {
+	s.token = tok
+	s.found = true
 }
 
 // Search through string and get next token
@@ -182,6 +238,7 @@ func (s *Tokenizer) nextToken() {
 		for s.peek() != '\n' && !s.end() {
 			s.advance()
 		}
+		s.comments = append(s.comments, Comment{s.line, string(s.lexeme[1:])})
 
 	// New line
 	case '\n':
@@ -195,17 +252,36 @@ func (s *Tokenizer) nextToken() {
 		} else if unicode.IsLetter(c) {
 			s.processIdentifier()
 		} else if !unicode.IsSpace(c) {
-			Error(s.line, "Unexpected character.")
+			s.error("Unexpected character.")
 		}
 	}
 }
 
-// Go through source and create a tokenized list
-func (s *Tokenizer) Tokenize() []Token {
+// Scan the next token, EOF once the source is used up
+func (s *Tokenizer) Next() Token {
 	for !s.end() {
-		s.start = s.current
+		s.lexeme = s.lexeme[:0]
+		s.found = false
 		s.nextToken()
+
+		// whitespace, comments and errors produce no token
+		if s.found {
+			return s.token
+		}
+	}
+	return *NewToken(EOF, "", "", s.line)
+}
+
+// Go through source and create a tokenized list
+func (s *Tokenizer) Tokenize() []Token {
+	tokens := []Token{}
+
+	for {
+		tok := s.Next()
+		tokens = append(tokens, tok)
+
+		if tok.GetType() == EOF {
+			return tokens
+		}
 	}
-	s.tokens = append(s.tokens, *NewToken(EOF, "", "", s.line))
-	return s.tokens
 }
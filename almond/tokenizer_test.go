@@ -0,0 +1,79 @@
+package almond
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// Reading a byte at a time must give the same tokens as a string source
+func TestStreamTokenizerMatchesString(t *testing.T) {
+	src := benchSource(1) + `
+		# a comment
+		var s = "two
+		lines"; print 1.5 >= 2 != !true; @ x.y`
+
+	want := NewTokenizer(src)
+	want.SetOutput(io.Discard)
+	wantTokens := want.Tokenize()
+
+	got := NewStreamTokenizer(iotest.OneByteReader(strings.NewReader(src)))
+	got.SetOutput(io.Discard)
+	gotTokens := got.Tokenize()
+
+	if len(gotTokens) != len(wantTokens) {
+		t.Fatalf("got %d tokens, want %d", len(gotTokens), len(wantTokens))
+	}
+
+	for idx := range wantTokens {
+		if gotTokens[idx] != wantTokens[idx] {
+			t.Errorf("token %d: got %v, want %v", idx, gotTokens[idx], wantTokens[idx])
+		}
+	}
+
+	if len(got.Comments()) != 1 || got.Comments()[0] != want.Comments()[0] {
+		t.Errorf("got comments %v, want %v", got.Comments(), want.Comments())
+	}
+
+	if len(got.Faults()) != 1 || len(want.Faults()) != 1 {
+		t.Errorf("got faults %v, want %v", got.Faults(), want.Faults())
+	}
+}
+
+// Tokens are handed out before the rest of the input has arrived
+func TestStreamTokenizerIsLazy(t *testing.T) {
+	reader, writer := io.Pipe()
+	tokenizer := NewStreamTokenizer(reader)
+
+	go writer.Write([]byte("print 1;\n"))
+
+	for _, want := range []TokenType{PRINT, NUMBER, SEMI_COLON} {
+		if tok := tokenizer.Next(); tok.GetType() != want {
+			t.Fatalf("got %v, want %v", tok, want)
+		}
+	}
+
+	writer.Close()
+
+	if tok := tokenizer.Next(); tok.GetType() != EOF || tok.GetLine() != 2 {
+		t.Errorf("got %v on line %d, want EOF on line 2", tok, tok.GetLine())
+	}
+}
+
+func TestStreamTokenizerReadError(t *testing.T) {
+	src := io.MultiReader(strings.NewReader("print 1"), iotest.ErrReader(errors.New("disk gone")))
+	tokenizer := NewStreamTokenizer(src)
+	tokenizer.SetOutput(io.Discard)
+
+	parser := NewStreamParser(tokenizer)
+	parser.SetOutput(io.Discard)
+	parser.Parse()
+
+	faults := tokenizer.Faults()
+
+	if len(faults) != 1 || faults[0].Error() != "[line 1] Error: Could not read source: disk gone" {
+		t.Errorf("got faults %v", faults)
+	}
+}
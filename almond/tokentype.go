@@ -53,6 +53,10 @@ const (
 
 	// Internal usage
 	CALLABLE
+
+	// Added since, appended so existing types keep their values
+	ASSERT
+	HOST
 )
 
 // TokenType to string mapping
@@ -100,10 +104,15 @@ var tokenTypeNames = map[TokenType]string{
 	FOR:    "FOR",
 	WHILE:  "WHILE",
 	PRINT:  "PRINT",
+	ASSERT: "ASSERT",
 	SUPER:  "SUPER",
 	THIS:   "THIS",
 	NULL:   "NULL",
 	EOF:    "EOF",
+
+	// Internal usage
+	CALLABLE: "CALLABLE",
+	HOST:     "HOST",
 }
 
 // Look-up table: string -> TokenType
@@ -119,6 +128,7 @@ var keywordMap = map[string]TokenType{
 	"for":    FOR,
 	"while":  WHILE,
 	"print":  PRINT,
+	"assert": ASSERT,
 	"super":  SUPER,
 	"this":   THIS,
 	"null":   NULL,
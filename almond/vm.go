@@ -0,0 +1,295 @@
+package almond
+
+import (
+	"context"
+	"fmt"
+)
+
+// Use the tree-walker instead of the bytecode vm, hooks always use the tree-walker
+func (i *Interpreter) SetTreeWalk(enabled bool) {
+	i.treeWalk = enabled
+}
+
+// Whether statements run on the tree-walker
+func (i *Interpreter) walking() bool {
+	return i == nil || i.treeWalk || i.hooks != nil
+}
+
+func (i *Interpreter) push(value Object) {
+	i.stack = append(i.stack, value)
+}
+
+func (i *Interpreter) pop() Object {
+	value := i.stack[len(i.stack)-1]
+	i.stack = i.stack[:len(i.stack)-1]
+	return value
+}
+
+func (i *Interpreter) peek() *Object {
+	return &i.stack[len(i.stack)-1]
+}
+
+// Run a chunk in env and return its return value or script result.
+// Nested calls share the value stack above the caller's values.
+func (i *Interpreter) runChunk(chunk *Chunk, env *Environment) (Object, error) {
+	base := len(i.stack)
+	value, err := i.loop(chunk, env)
+
+	// an error can leave values behind
+	clear(i.stack[base:])
+	i.stack = i.stack[:base]
+
+	return value, err
+}
+
+// Run a script compiled ahead of time, such as one loaded from a .alc file
+func (i *Interpreter) runCompiled(chunk *Chunk) error {
+	defer i.begin(context.Background())()
+
+	_, err := i.runChunk(chunk, &i.env)
+	return err
+}
+
+func (i *Interpreter) loop(chunk *Chunk, scope *Environment) (Object, error) {
+	env := scope
+	code := chunk.code
+
+	// blocks left early by a return or an error give back their locals
+	defer func() { i.releaseTo(env, scope) }()
+
+	result := nullValue()
+
+	for pc := 0; pc < len(code); {
+		op := OpCode(code[pc])
+		pc++
+
+		switch op {
+		case OP_CONSTANT:
+			i.push(chunk.constants[chunk.u16(pc)])
+			pc += 2
+
+		case OP_POP:
+			i.pop()
+
+		case OP_RESULT:
+			result = i.pop()
+
+		case OP_GET:
+			value, err := env.Get(chunk.tokens[chunk.u16(pc)])
+			pc += 2
+
+			if err != nil {
+				return Object{}, err
+			}
+			i.push(value)
+
+		case OP_SET:
+			err := env.Assign(chunk.tokens[chunk.u16(pc)], *i.peek())
+			pc += 2
+
+			if err != nil {
+				return Object{}, err
+			}
+
+		case OP_DEFINE:
+			env.Define(chunk.tokens[chunk.u16(pc)].GetLexeme(), i.pop())
+			pc += 2
+
+		case OP_GET_LOCAL:
+			local := &chunk.locals[chunk.u16(pc)]
+			value, err := env.getAt(local.depth, local.slot, local.name)
+			pc += 2
+
+			if err != nil {
+				return Object{}, err
+			}
+			i.push(value)
+
+		case OP_SET_LOCAL:
+			local := &chunk.locals[chunk.u16(pc)]
+			err := env.assignAt(local.depth, local.slot, local.name, *i.peek())
+			pc += 2
+
+			if err != nil {
+				return Object{}, err
+			}
+
+		case OP_DEFINE_LOCAL:
+			env.setSlot(chunk.u16(pc), i.pop())
+			pc += 2
+
+		case OP_PROPERTY:
+			value, err := getProperty(i, i.pop(), chunk.tokens[chunk.u16(pc)])
+			pc += 2
+
+			if err != nil {
+				return Object{}, err
+			}
+			i.push(value)
+
+		case OP_UNARY:
+			value, err := unaryOp(chunk.tokens[chunk.u16(pc)], i.pop())
+			pc += 2
+
+			if err != nil {
+				return Object{}, err
+			}
+			i.push(value)
+
+		case OP_BINARY:
+			left := i.pop()
+			right := i.pop()
+			value, err := binaryOp(env, chunk.tokens[chunk.u16(pc)], left, right)
+			pc += 2
+
+			if err != nil {
+				return Object{}, err
+			}
+			i.push(value)
+
+		case OP_COMPARE:
+			top := len(i.stack)
+			value, err := binaryOp(env, chunk.tokens[chunk.u16(pc)], i.stack[top-1], i.stack[top-2])
+			pc += 2
+
+			if err != nil {
+				return Object{}, err
+			}
+			i.push(value)
+
+		case OP_JUMP:
+			pc += 2 + chunk.u16(pc)
+
+		case OP_JUMP_IF_FALSE:
+			if i.peek().Bool() {
+				pc += 2
+			} else {
+				pc += 2 + chunk.u16(pc)
+			}
+
+		case OP_JUMP_IF_TRUE:
+			if i.peek().Bool() {
+				pc += 2 + chunk.u16(pc)
+			} else {
+				pc += 2
+			}
+
+		case OP_POP_JUMP_IF_FALSE:
+			cond := i.pop()
+
+			if cond.Bool() {
+				pc += 2
+			} else {
+				pc += 2 + chunk.u16(pc)
+			}
+
+		case OP_LOOP:
+			pc += 2 - chunk.u16(pc)
+
+		case OP_CHECKPOINT:
+			err := i.checkpoint(chunk.tokens[chunk.u16(pc)])
+			pc += 2
+
+			if err != nil {
+				return Object{}, err
+			}
+
+		case OP_CALL, OP_TAIL_CALL:
+			argc := int(code[pc])
+			tok := chunk.tokens[chunk.u16(pc+1)]
+			pc += 3
+
+			top := len(i.stack)
+			callee := i.stack[top-argc-1]
+
+			var args []Object
+			if argc > 0 {
+				args = make([]Object, argc)
+				copy(args, i.stack[top-argc:])
+			}
+			i.stack = i.stack[:top-argc-1]
+
+			function, ok := callee.ref.(Callable)
+
+			if !ok {
+				return Object{}, RuntimeError("type was not of a callable type", tok)
+			}
+
+			// the caller makes the call in place of this frame
+			if fn, ok := function.(*FunctionCall); ok && op == OP_TAIL_CALL {
+				return Object{}, &tailCall{fn, args, tok}
+			}
+
+			value, err := callFunction(env, function, args, tok)
+
+			if err != nil {
+				return Object{}, err
+			}
+
+			if op == OP_TAIL_CALL {
+				return value, nil
+			}
+			i.push(value)
+
+		case OP_PRINT:
+			value := i.pop()
+			i.print(value.String())
+
+		case OP_ASSERT:
+			info := chunk.asserts[chunk.u16(pc)]
+			cond := i.pop()
+
+			if cond.Bool() {
+				if info.compare {
+					i.stack = i.stack[:len(i.stack)-2]
+				}
+				pc += 4 + chunk.u16(pc+2)
+			} else {
+				pc += 4
+			}
+
+		case OP_ASSERT_FAIL:
+			info := chunk.asserts[chunk.u16(pc)]
+			pc += 2
+
+			suffix := ""
+			if info.message {
+				message := i.pop()
+				suffix = ": " + message.String()
+			}
+
+			detail := ""
+			if info.compare {
+				left := i.pop()
+				right := i.pop()
+				detail = " (left: " + valueSource(left) + ", right: " + valueSource(right) + ")"
+			}
+
+			return Object{}, RuntimeError("assert failed: "+info.source+detail+suffix, info.keyword)
+
+		case OP_BEGIN_SCOPE:
+			env = env.enterBlock(chunk.frames[chunk.u16(pc)])
+			pc += 2
+
+		case OP_END_SCOPE:
+			i.release(env)
+			env = env.enclosing
+
+		case OP_FUNCTION:
+			fn := chunk.functions[chunk.u16(pc)]
+			pc += 2
+
+			function := NewFunctionCall(fn.declaration, env)
+			function.chunk = fn.chunk
+			env.defineAt(fn.declaration.slot, fn.declaration.name.GetLexeme(), callableValue(function))
+
+		case OP_RETURN:
+			return i.pop(), nil
+
+		default:
+			return Object{}, fmt.Errorf("%w: unknown opcode %s", ErrInternal, op)
+		}
+	}
+
+	return result, nil
+}
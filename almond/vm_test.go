@@ -0,0 +1,183 @@
+package almond
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+// Scripts run on both backends, covering every statement, operator and error path
+var differentialScripts = map[string]string{
+	"arithmetic": `print 1 + 2 * 3 - 4 / 8; print -(3 - 5); print !null; print !!0; 7 - 2;`,
+	"strings":    `var s = "al" + "mond"; print s; print s == "almond"; print s != "x"; s;`,
+	"logical": `
+		fn side(v) { print "side " + v; return v; }
+		print side("a") | side("b");
+		print null & side("c");
+		print false | null;`,
+	"scopes": `
+		var a = "global";
+		{ var a = "outer"; { var a = "inner"; print a; } print a; }
+		print a;
+		a = "assigned";
+		print a;`,
+	"loops": `
+		var total = 0;
+		for (var i = 0; i < 10; i = i + 1) { if (i == 5) total = total + 100; else total = total + i; }
+		while (total > 100) total = total - 7;
+		print total;`,
+	"closures": `
+		fn counter() {
+			var n = 0;
+			fn next() { n = n + 1; return n; }
+			return next;
+		}
+		var c = counter();
+		c(); c();
+		print c();
+		print counter()();
+		print c;`,
+	"recursion": `
+		fn fib(n) { if (n <= 1) return n; return fib(n - 2) + fib(n - 1); }
+		print fib(15);
+		fn early(n) { while (true) { { if (n > 3) return n; } n = n + 1; } }
+		early(0);`,
+	"top level return": `print 1; return 2; print 3;`,
+	"no result":        `var x = 1;`,
+	"prelude":          `print max(3, abs(-9)); print repeat("ab", 3);`,
+	"asserts": `
+		assert 1 < 2;
+		assert 2 == 2, "never shown";
+		fn f() { return 4; }
+		assert f() + 1 == 6, "f is " + "off";`,
+	"assert plain":   `var ok = null; assert ok;`,
+	"type mismatch":  `print 1; print "a" + 1;`,
+	"bad operand":    `print -"a";`,
+	"undefined":      `print missing;`,
+	"undefined set":  `missing = 3;`,
+	"not callable":   `var x = 3; x(1, 2);`,
+	"arity":          `fn f(a) { return a; } f(1, 2);`,
+	"stack overflow": `fn f(n) { return 1 + f(n + 1); } f(0);`,
+	"native error":   `sleepMS("long");`,
+	"tail calls": `
+		fn even(n) { if (n == 0) return true; return odd(n - 1); }
+		fn odd(n) { if (n == 0) return false; return even(n - 1); }
+		print even(101);
+		fn nap() { return sleepMS(0); }
+		print nap();
+		fn pick(n) { { var half = n / 2; if (half < 1) return n; return pick(half); } }
+		print pick(40);`,
+	"tail call arity": `fn g(a) { return a; } fn f() { return g(); } f();`,
+	"tail call trace": `
+		fn fail(n) { return n + "s"; }
+		fn count(n) { if (n == 0) return fail(n); return count(n - 1); }
+		fn outer() { var v = count(5); return v; }
+		outer();`,
+	"property":        `var x = 3; print x.field;`,
+	"error in assert": `assert missing == 1;`,
+	"error in message": `
+		assert 1 > 2, missing;`,
+}
+
+// run src on one backend and capture everything it reports
+func runBackend(t *testing.T, src string, treeWalk bool) string {
+	var stdout, stderr bytes.Buffer
+	engine, err := NewEngine(Options{Stdout: &stdout, Stderr: &stderr, TreeWalk: treeWalk, MaxCallDepth: 50})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := engine.Eval(src)
+	errText := ""
+	if err != nil {
+		errText = err.Error()
+	}
+
+	var fault *RuntimeFault
+	if errors.As(err, &fault) {
+		errText += "\n" + fault.StackTrace()
+	}
+
+	return "stdout:\n" + stdout.String() + "stderr:\n" + stderr.String() +
+		"value: " + value.String() + "\nerror: " + errText
+}
+
+// The bytecode vm must behave exactly like the tree-walker
+func TestVMMatchesTreeWalker(t *testing.T) {
+	for name, src := range differentialScripts {
+		t.Run(name, func(t *testing.T) {
+			// make sure the vm does not fall back to the tree-walker
+			statements, err := parseSource(src)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := compile(statements); err != nil {
+				t.Fatal(err)
+			}
+
+			vm := runBackend(t, src, false)
+			tree := runBackend(t, src, true)
+
+			if vm != tree {
+				t.Errorf("backends differ\n--- vm\n%s\n--- tree-walker\n%s", vm, tree)
+			}
+		})
+	}
+}
+
+// Functions declared on the vm keep working after switching backends
+func TestVMFunctionsRunOnTreeWalker(t *testing.T) {
+	var stdout bytes.Buffer
+	engine, _ := NewEngine(Options{Stdout: &stdout})
+
+	_, err := engine.Eval(`fn twice(x) { return x * 2; }`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	engine.inter.SetTreeWalk(true)
+	value, err := engine.Call("twice", 21)
+
+	if err != nil || value.String() != "42" {
+		t.Fatalf("got %s, %v", value.String(), err)
+	}
+}
+
+// Statement the compiler has no case for
+type unknownStmt struct {
+	ran *bool
+}
+
+func (s unknownStmt) Evaluate(e *Environment) error {
+	*s.ran = true
+	return nil
+}
+
+func (s unknownStmt) Line() int {
+	return 1
+}
+
+// Bugs in the compiler or vm are returned as internal errors
+func TestInternalErrors(t *testing.T) {
+	var ran bool
+	statements := []Stmt{unknownStmt{&ran}}
+
+	if _, err := compile(statements); !errors.Is(err, ErrInternal) {
+		t.Errorf("compile: got %v", err)
+	}
+
+	// code the compiler cannot handle still runs on the tree-walker
+	inter, _ := NewInterpreter()
+
+	if _, err := inter.evaluate(context.Background(), statements); err != nil || !ran {
+		t.Errorf("evaluate: ran %v, %v", ran, err)
+	}
+
+	if err := inter.runCompiled(&Chunk{code: []byte{250}}); !errors.Is(err, ErrInternal) {
+		t.Errorf("vm: got %v", err)
+	}
+}
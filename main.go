@@ -2,13 +2,63 @@ package main
 
 import (
 	"Interpreter/almond"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
-// method to interact with interpreter: shell || src file
+// method to interact with interpreter: shell || src file || subcommand
 func main() {
-	args := os.Args[1:]
+	tree := flag.Bool("tree", false, "run on the tree-walking interpreter instead of the bytecode vm")
+	noOpt := flag.Bool("no-opt", false, "run without constant folding and dead code removal")
+	buffer := flag.String("buffer", "", "print output buffering: line or full, defaults to line on a terminal")
+	allow := flag.String("allow", "", "comma separated natives to grant besides clock and sleepMS: filesystem, env, exec or all")
+	flag.Parse()
+
+	args := flag.Args()
+	opts := almond.RunOptions{TreeWalk: *tree, NoOptimize: *noOpt}
+
+	caps, err := capabilities(*allow)
+
+	if err != nil {
+		fmt.Println("Usage:", err)
+		os.Exit(64)
+	}
+	opts.Capabilities = &caps
+
+	switch *buffer {
+	case "line":
+		opts.LineBuffered = true
+	case "full":
+	case "":
+		opts.LineBuffered = isTerminal(os.Stdout)
+	default:
+		fmt.Println("Usage: -buffer must be line or full")
+		os.Exit(64)
+	}
+
+	if len(args) > 0 && args[0] == "run" {
+		runFile(args[1:], opts)
+		return
+	}
+
+	if len(args) > 0 && args[0] == "lint" {
+		lint(args[1:])
+		return
+	}
+
+	if len(args) > 0 && args[0] == "bench" {
+		bench(args[1:], opts)
+		return
+	}
+
+	if len(args) > 0 && args[0] == "compile" {
+		compile(args[1:], opts)
+		return
+	}
 
 	if len(args) > 1 {
 		fmt.Println("Usage: too many arguments -> try to pass path to source code or run with no arguments")
@@ -16,10 +66,177 @@ func main() {
 
 	} else if len(args) == 1 {
 		// Run file
-		almond.RunFile(args[0])
+		err := almond.RunFile(args[0], opts)
+
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(66)
+		}
 
 	} else {
 		// Interative shell
-		almond.RunPrompt()
+		almond.RunPrompt(opts)
+	}
+}
+
+// natives granted by the -allow flag, scripts only get the defaults without it
+func capabilities(allow string) (almond.Capabilities, error) {
+	caps := almond.DefaultCapabilities
+
+	for _, name := range strings.Split(allow, ",") {
+		switch strings.TrimSpace(name) {
+		case "":
+		case "filesystem":
+			caps.FileSystem = true
+		case "env":
+			caps.Env = true
+		case "exec":
+			caps.Exec = true
+		case "all":
+			caps = almond.AllCapabilities
+		default:
+			return caps, errors.New("-allow must list filesystem, env, exec or all, got " + name)
+		}
+	}
+	return caps, nil
+}
+
+// output to a terminal is read as it is printed
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// run a source file, optionally profiling it
+func runFile(args []string, opts almond.RunOptions) {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	cpuprofile := flags.String("cpuprofile", "", "write a pprof profile of the script's functions and lines to this file")
+	flags.Parse(args)
+	filename := flags.Arg(0)
+
+	// flags may also follow the file name
+	if filename != "" {
+		flags.Parse(flags.Args()[1:])
+	}
+
+	if filename == "" || flags.NArg() > 0 {
+		fmt.Println("Usage: run [-cpuprofile output] <filename>")
+		os.Exit(64)
+	}
+
+	opts.CPUProfile = *cpuprofile
+	err := almond.RunFile(filename, opts)
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(66)
+	}
+}
+
+// report static warnings for a source file
+func lint(args []string) {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	disable := flags.String("disable", "", "comma separated rules to skip: "+strings.Join(almond.LintRules, ", "))
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Println("Usage: lint [-disable rule,...] <filename>")
+		os.Exit(64)
+	}
+
+	opts := almond.LintOptions{Disabled: map[string]bool{}}
+	for _, rule := range strings.Split(*disable, ",") {
+		if rule != "" {
+			opts.Disabled[strings.TrimSpace(rule)] = true
+		}
+	}
+
+	diags, err := almond.LintFile(flags.Arg(0), opts)
+
+	for _, diag := range diags {
+		fmt.Println(diag)
+	}
+
+	// syntax errors were already printed by the parser
+	var fault *almond.ParseFault
+	if errors.As(err, &fault) {
+		os.Exit(65)
+	} else if err != nil {
+		fmt.Println(err)
+		os.Exit(66)
+	}
+}
+
+// time repeated runs of a source file
+func bench(args []string, opts almond.RunOptions) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	runs := flags.Int("n", 10, "number of runs")
+	flags.Parse(args)
+	filename := flags.Arg(0)
+
+	// flags may also follow the file name
+	if filename != "" {
+		flags.Parse(flags.Args()[1:])
+	}
+
+	if filename == "" || flags.NArg() > 0 {
+		fmt.Println("Usage: bench [-n runs] <filename>")
+		os.Exit(64)
+	}
+
+	result, err := almond.BenchFile(filename, *runs, opts)
+
+	var parseFault *almond.ParseFault
+	var runtimeFault *almond.RuntimeFault
+
+	if errors.As(err, &parseFault) {
+		fmt.Println(err)
+		os.Exit(65)
+	} else if errors.As(err, &runtimeFault) {
+		fmt.Println(err)
+		os.Exit(70)
+	} else if err != nil {
+		fmt.Println(err)
+		os.Exit(66)
+	}
+
+	fmt.Printf("%s: %d runs\n", filename, len(result.Times))
+	fmt.Printf("  mean    %v\n", result.Mean())
+	fmt.Printf("  median  %v\n", result.Median())
+	fmt.Printf("  min     %v\n", result.Min())
+	fmt.Printf("  max     %v\n", result.Max())
+	fmt.Printf("  allocs  %d per run, %d bytes\n", result.Allocs, result.Bytes)
+}
+
+// write bytecode for a source file
+func compile(args []string, opts almond.RunOptions) {
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	output := flags.String("o", "", "output file, defaults to the source name with .alc")
+	flags.Parse(args)
+	filename := flags.Arg(0)
+
+	// flags may also follow the file name
+	if filename != "" {
+		flags.Parse(flags.Args()[1:])
+	}
+
+	if filename == "" || flags.NArg() > 0 {
+		fmt.Println("Usage: compile [-o output] <filename>")
+		os.Exit(64)
+	}
+
+	if *output == "" {
+		*output = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".alc"
+	}
+
+	err := almond.CompileFile(filename, *output, opts)
+
+	// syntax errors were already printed by the parser
+	var fault *almond.ParseFault
+	if errors.As(err, &fault) {
+		os.Exit(65)
+	} else if err != nil {
+		fmt.Println(err)
+		os.Exit(66)
 	}
 }